@@ -0,0 +1,100 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Builder assembles a file in memory from field definitions and values,
+// for tests and examples. Each method returns the Builder so the calls
+// chain; the first error is kept and returned by Write or Bytes.
+//
+//	b := NewBuilder()
+//	b.Define("TEST", "Test field", "NAME!NUMB", "(A,I(3))")
+//	b.Record("TEST", "abc", 42)
+//	data, err := b.Bytes()
+type Builder struct {
+	Lead    LeadRecord
+	Records []*DataRecord
+	err     error
+}
+
+// NewBuilder returns a Builder with an interchange level 3 lead record
+// and no field types.
+func NewBuilder() *Builder {
+	return &Builder{Lead: LeadRecord{
+		Header:     Header{InterchangeLevel: '3'},
+		FieldTypes: make(map[string]FieldType),
+	}}
+}
+
+// Define adds a mixed data type FieldType. The field is an array when
+// the array descriptor starts with '*', elementary when it is empty, as
+// for the S-57 record identifier field 0001, and linear otherwise.
+func (b *Builder) Define(tag, name, arrayDescriptor, formatControls string) *Builder {
+	ft := FieldType{Tag: tag, DataStructure: byte(Linear), DataType: byte(MixedDataTypes), Name: []byte(name),
+		ArrayDescriptor: []byte(arrayDescriptor), FormatControls: []byte(formatControls)}
+	switch {
+	case arrayDescriptor == "":
+		ft.DataStructure = byte(Elementary)
+	case arrayDescriptor[0] == '*':
+		ft.DataStructure = byte(Array)
+	}
+	b.Lead.FieldTypes[tag] = ft
+	return b
+}
+
+// Record starts a data record whose first field is tag with the values.
+func (b *Builder) Record(tag string, values ...interface{}) *Builder {
+	b.Records = append(b.Records, &DataRecord{Lead: &b.Lead})
+	return b.Field(tag, values...)
+}
+
+// Field adds a field with the values to the last record. The tag must
+// have been defined.
+func (b *Builder) Field(tag string, values ...interface{}) *Builder {
+	if b.err != nil {
+		return b
+	}
+	ft, ok := b.Lead.FieldTypes[tag]
+	switch {
+	case !ok:
+		b.err = fmt.Errorf("field %s is not defined", tag)
+	case len(b.Records) == 0:
+		b.err = fmt.Errorf("field %s: no record, call Record first", tag)
+	default:
+		d := b.Records[len(b.Records)-1]
+		d.Fields = append(d.Fields, Field{Tag: tag, FieldType: ft, SubFields: values})
+	}
+	return b
+}
+
+// Write writes the lead record and the data records.
+func (b *Builder) Write(file io.Writer) error {
+	if b.err != nil {
+		return b.err
+	}
+	if err := b.Lead.Write(file); err != nil {
+		return err
+	}
+	for _, d := range b.Records {
+		if err := d.Write(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Bytes returns the file Write writes.
+func (b *Builder) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
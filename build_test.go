@@ -0,0 +1,74 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	b := NewBuilder()
+	b.Define("0001", "ISO 8211 Record Identifier", "", "(b12)")
+	b.Define("PNTS", "Points", "*XCOO!YCOO", "(2b24)")
+	b.Record("0001", uint16(1)).Field("PNTS", int32(1), int32(2), int32(3), int32(4))
+	data, err := b.Bytes()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	f, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	if s := f.Lead.FieldTypes["0001"].Structure(); s != Elementary {
+		t.Error("Expected elementary, got ", s)
+	}
+	if s := f.Lead.FieldTypes["PNTS"].Structure(); s != Array {
+		t.Error("Expected an array, got ", s)
+	}
+	d, err := f.Next()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if id, _ := d.Field("0001"); len(id.SubFields) != 1 || id.SubFields[0] != uint16(1) {
+		t.Error("Expected record 1, got ", id.SubFields)
+	}
+	pnts, _ := d.Field("PNTS")
+	if e := []interface{}{int32(1), int32(2), int32(3), int32(4)}; !reflect.DeepEqual(pnts.SubFields, e) {
+		t.Error("Expected ", e, ", got ", pnts.SubFields)
+	}
+	if _, err = b.Field("NONE").Bytes(); err == nil {
+		t.Error("Expected an error for the undefined field")
+	}
+	if _, err = NewBuilder().Define("TEST", "", "A", "(A)").Field("TEST", "x").Bytes(); err == nil {
+		t.Error("Expected an error for a field without a record")
+	}
+}
+
+func ExampleBuilder() {
+	b := NewBuilder()
+	b.Define("TEST", "Test field", "NAME!NUMB", "(A,I(3))")
+	b.Record("TEST", "abc", 42)
+	data, err := b.Bytes()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	f, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	d, err := f.Next()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	test, _ := d.Field("TEST")
+	fmt.Println(test.SubField("NUMB"))
+	// Output: 42 true
+}
@@ -0,0 +1,91 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+// Clone returns a deep copy of the record, its Header, Fields and
+// DecodeTags, that can be changed without affecting the original. The
+// Lead is shared.
+func (data *DataRecord) Clone() *DataRecord {
+	c := *data
+	c.buf = nil
+	if data.DecodeTags != nil {
+		c.DecodeTags = make(map[string]bool, len(data.DecodeTags))
+		for tag, ok := range data.DecodeTags {
+			c.DecodeTags[tag] = ok
+		}
+	}
+	c.Header = data.Header.Clone()
+	c.Fields = make([]Field, len(data.Fields))
+	for i := range data.Fields {
+		c.Fields[i] = data.Fields[i].Clone()
+	}
+	return &c
+}
+
+// Clone returns a deep copy of the Header and its directory.
+func (header Header) Clone() Header {
+	header.ExtendedCharacterSetIndicator = cloneBytes(header.ExtendedCharacterSetIndicator)
+	entries := header.Entries
+	if entries != nil {
+		header.Entries = make([]DirEntry, len(entries))
+		for i, e := range entries {
+			e.Tag = cloneBytes(e.Tag)
+			header.Entries[i] = e
+		}
+	}
+	return header
+}
+
+// Clone returns a deep copy of the Field, its FieldType, SubFields and
+// Raw data.
+func (field Field) Clone() Field {
+	field.FieldType = field.FieldType.Clone()
+	if field.SubFields != nil {
+		values := make([]interface{}, len(field.SubFields))
+		for i, v := range field.SubFields {
+			if b, ok := v.([]byte); ok {
+				v = cloneBytes(b)
+			}
+			values[i] = v
+		}
+		field.SubFields = values
+	}
+	field.Raw = cloneBytes(field.Raw)
+	return field
+}
+
+// Clone returns a deep copy of the FieldType. The copy has its own Format
+// cache, so its format controls may be changed. SubFields, when set, are
+// copied and still take the place of the format controls.
+func (dir FieldType) Clone() FieldType {
+	dir.format = nil
+	if types := dir.SubFields; types != nil {
+		dir.SubFields = make([]SubFieldType, len(types))
+		for i, t := range types {
+			t.Tag = cloneBytes(t.Tag)
+			dir.SubFields[i] = t
+		}
+	}
+	dir.AuxiliaryControls = cloneBytes(dir.AuxiliaryControls)
+	dir.EscapeSeq = cloneBytes(dir.EscapeSeq)
+	dir.Name = cloneBytes(dir.Name)
+	dir.ArrayDescriptor = cloneBytes(dir.ArrayDescriptor)
+	dir.FormatControls = cloneBytes(dir.FormatControls)
+	if dir.Extra != nil {
+		extra := make([][]byte, len(dir.Extra))
+		for i, part := range dir.Extra {
+			extra[i] = cloneBytes(part)
+		}
+		dir.Extra = extra
+	}
+	return dir
+}
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
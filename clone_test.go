@@ -0,0 +1,41 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDataRecordClone(t *testing.T) {
+	d := readTestRecords(t)[1]
+	c := d.Clone()
+	if !reflect.DeepEqual(c.Fields[3].SubFields, d.Fields[3].SubFields) || c.String() != d.String() || c.Lead != d.Lead {
+		t.Fatal("Expected an equal clone, got ", c)
+	}
+	c.Header.Entries[3].Tag[0] = 'X'
+	c.Fields[3].SubFields[1] = "6"
+	c.Fields[3].FieldType.ArrayDescriptor[1] = 'X'
+	c.Fields[3].FieldType.Format()[0].Tag[0] = 'X'
+	if string(d.Header.Entries[3].Tag) != "ATTF" || d.Fields[3].SubFields[1] != "5" {
+		t.Error("Changing the clone changed the record ", d)
+	}
+	if ft := d.Fields[3].FieldType; string(ft.ArrayDescriptor) != "*ATTL!ATVL" || string(ft.Format()[0].Tag) != "ATTL" {
+		t.Error("Changing the clone changed the field type ", ft)
+	}
+	ft := d.Fields[3].FieldType.Clone()
+	ft.FormatControls = []byte("(b12,b12)")
+	if types := ft.Format(); len(types) != 2 || types[1].Kind != reflect.Uint16 {
+		t.Error("Expected the changed format controls, got ", types)
+	}
+	d.DecodeTags = map[string]bool{"FRID": true}
+	if c = d.Clone(); !reflect.DeepEqual(c.DecodeTags, d.DecodeTags) {
+		t.Error("Expected the DecodeTags, got ", c.DecodeTags)
+	}
+	c.DecodeTags["ATTF"] = true
+	if d.DecodeTags["ATTF"] {
+		t.Error("Changing the clone changed the DecodeTags")
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+)
+
+// Issue is a single deviation from the ISO 8211 specification.
+type Issue struct {
+	Record int    // 0 is the lead record, data records count from 1.
+	Tag    string // The field tag, empty for leader and directory issues.
+	Msg    string
+}
+
+func (i Issue) String() string {
+	if i.Tag == "" {
+		return fmt.Sprintf("record %d: %s", i.Record, i.Msg)
+	}
+	return fmt.Sprintf("record %d field %s: %s", i.Record, i.Tag, i.Msg)
+}
+
+// ConformanceReport lists every issue found in a file.
+type ConformanceReport struct {
+	Records     int // The number of data records checked.
+	Issues      []Issue
+	terminators Terminators
+}
+
+// OK reports whether the file had no issues.
+func (report *ConformanceReport) OK() bool {
+	return len(report.Issues) == 0
+}
+
+func (report *ConformanceReport) add(record int, tag, format string, a ...interface{}) {
+	report.Issues = append(report.Issues, Issue{record, tag, fmt.Sprintf(format, a...)})
+}
+
+// Conformance reads a whole file and reports every spec deviation found:
+// leader values, directory entries that do not tile the field area,
+// unknown format controls, subfield counts that do not fit the format,
+// bytes left over after decoding and non-ASCII text in ASCII fields.
+// The leader and directory issues are those Strict reading rejects, and
+// the format control issues those of LeadRecord.Validate. An error is
+// returned only when the lead record cannot be read at all; a truncated
+// data record is reported as an issue.
+func Conformance(r io.Reader) (*ConformanceReport, error) {
+	return ConformanceTerminators(r, Terminators{})
+}
+
+// ConformanceTerminators is Conformance for files written with other
+// terminators than the standard ones.
+func ConformanceTerminators(r io.Reader, t Terminators) (*ConformanceReport, error) {
+	report := &ConformanceReport{terminators: t}
+	cr := &countingReader{r: r}
+	lead := LeadRecord{Terminators: t}
+	fields, err := report.readRecord(cr, 0, &lead.Header)
+	if err != nil {
+		return nil, err
+	}
+	lead.FieldTypes = make(map[string]FieldType, len(lead.Header.Entries))
+	for i, d := range lead.Header.Entries {
+		if fields[i] == nil {
+			continue
+		}
+		ft := FieldType{Tag: string(d.Tag), Length: d.Length, Position: d.Position}
+		ft.controlLength = int(lead.Header.FieldControlLength)
+		ft.Terminators = lead.Terminators
+		if err := ft.Read(bytes.NewReader(fields[i])); err != nil {
+			report.add(0, ft.Tag, "bad field description: %v", err)
+			continue
+		}
+		for _, p := range ft.validate() {
+			report.add(0, ft.Tag, "%s", p)
+		}
+		lead.FieldTypes[ft.Tag] = ft
+	}
+	for rec := 1; ; rec++ {
+		var header Header
+		fields, err := report.readRecord(cr, rec, &header)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			report.add(rec, "", "%v", err)
+			break
+		}
+		report.Records++
+		for i, d := range header.Entries {
+			report.checkField(rec, lead.FieldTypes, string(d.Tag), fields[i])
+		}
+	}
+	return report, nil
+}
+
+// readRecord reads a header and its field area, reporting leader and
+// directory issues. It returns the data of each directory entry, nil for
+// an entry outside the field area.
+func (report *ConformanceReport) readRecord(r *countingReader, rec int, header *Header) ([][]byte, error) {
+	header.Offset = r.n
+	if err := header.Read(r); err != nil {
+		return nil, err
+	}
+	checks := []func() error{header.checkLeadID, header.checkLeader}
+	if rec > 0 {
+		checks = []func() error{header.checkDataID, header.checkDataLeader}
+	}
+	for _, check := range append(checks, header.Validate) {
+		if err := check(); err != nil {
+			report.add(rec, "", "%v", err)
+		}
+	}
+	if header.RecordLength <= header.BaseAddress {
+		return nil, fmt.Errorf("record length %d is not beyond the base address %d",
+			header.RecordLength, header.BaseAddress)
+	}
+	// Read what is there rather than allocate the length a crafted
+	// directory gives.
+	n := header.RecordLength - header.BaseAddress
+	area, err := ioutil.ReadAll(io.LimitReader(r, int64(n)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(area)) < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	fields := make([][]byte, len(header.Entries))
+	for i, d := range header.Entries {
+		if d.Position < 0 || d.Length < 1 || d.Position+d.Length > len(area) {
+			// Validate reported it.
+			continue
+		}
+		fields[i] = area[d.Position : d.Position+d.Length]
+		if fields[i][d.Length-1] != report.terminators.field() {
+			report.add(rec, string(d.Tag), "field does not end with a field terminator")
+		}
+	}
+	return fields, nil
+}
+
+// checkField decodes a data field and reports misaligned and non-ASCII
+// subfields.
+func (report *ConformanceReport) checkField(rec int, types map[string]FieldType, tag string, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	ft, ok := types[tag]
+	if !ok {
+		report.add(rec, tag, "no field description in the lead record")
+		return
+	}
+	format := ft.Format()
+	if len(format) == 0 {
+		return
+	}
+	values, err := ft.DecodeErr(data[:len(data)-1])
+	if err != nil {
+		report.add(rec, tag, "%v", err)
+	}
+	if len(values)%len(format) != 0 {
+		report.add(rec, tag, "%d subfields do not fit the %d subfield format", len(values), len(format))
+	}
+	if !bytes.Equal(ft.EscapeSeq, []byte("   ")) {
+		return
+	}
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok || format[i%len(format)].Kind != reflect.String {
+			continue
+		}
+		for _, c := range []byte(s) {
+			if c > 0x7f {
+				report.add(rec, tag, "non-ASCII byte 0x%02x in subfield %s", c, format[i%len(format)].Tag)
+				break
+			}
+		}
+	}
+}
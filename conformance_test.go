@@ -0,0 +1,74 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	report, err := Conformance(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if !report.OK() || report.Records != 2 {
+		t.Error("Expected 2 conformant records, got ", report)
+	}
+	// Clobber the field terminator of the last field.
+	bad := append([]byte{}, data...)
+	bad[len(bad)-1] = 'x'
+	report, err = Conformance(bytes.NewReader(bad))
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].Tag != "ATTF" {
+		t.Error("Expected an ATTF issue, got ", report)
+	}
+	report, err = Conformance(bytes.NewReader(data[:len(data)-1]))
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if report.Records != 1 || len(report.Issues) != 1 || report.Issues[0].Record != 2 {
+		t.Error("Expected a truncated record 2, got ", report)
+	}
+	// A data record with a blank record length and a 1GB field is read
+	// as far as the file goes, not allocated.
+	h := Header{InterchangeLevel: ' ', LeaderID: 'D', InLineCode: ' ', ApplicationIndicator: ' ', LengthSize: 9,
+		PositionSize: 1, TagSize: 4, BaseAddress: 39, Entries: []DirEntry{{[]byte("0001"), 999999999, 0}}}
+	huge, err := h.RawBytes()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	report, err = Conformance(bytes.NewReader(append(append([]byte{}, data[:1814]...), huge...)))
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if n := len(report.Issues); report.Records != 0 || n == 0 || report.Issues[n-1].Msg != io.ErrUnexpectedEOF.Error() {
+		t.Error("Expected a truncated record 1, got ", report)
+	}
+	// The leader checks of Strict reading.
+	bad = append([]byte{}, data...)
+	bad[5] = '9'
+	bad[1814+7] = 'E'
+	report, err = Conformance(bytes.NewReader(bad))
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	e := []Issue{
+		{0, "", "record at offset 0: interchange level '9' is not 1, 2 or 3"},
+		{1, "", "record at offset 1814: in line code extension indicator 'E' is not blank"},
+	}
+	if !reflect.DeepEqual(report.Issues, e) {
+		t.Error("Expected ", e, ", got ", report.Issues)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// WriteCSV writes the subfields of every field tagged tag in records as
+// CSV: a header row of the subfield tags, then a row for each field, or
+// for each repeat of the subfields of a repeating field such as SG2D.
+// Binary arrays are hex encoded and blank numbers are empty.
+func WriteCSV(w io.Writer, records []DataRecord, tag string) error {
+	out := csv.NewWriter(w)
+	header := false
+	for i := range records {
+		for _, f := range records[i].FieldsByTag(tag) {
+			types := f.FieldType.Format()
+			if len(types) == 0 {
+				return fmt.Errorf("field %s: no format to name the columns", tag)
+			}
+			if !header {
+				row := make([]string, len(types))
+				for j, t := range types {
+					row[j] = string(t.Tag)
+				}
+				if err := out.Write(row); err != nil {
+					return err
+				}
+				header = true
+			}
+			for r := 0; r < len(f.SubFields); r += len(types) {
+				end := r + len(types)
+				if end > len(f.SubFields) {
+					end = len(f.SubFields)
+				}
+				row := make([]string, 0, len(types))
+				for _, v := range f.SubFields[r:end] {
+					row = append(row, csvValue(v))
+				}
+				if err := out.Write(row); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func csvValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return hex.EncodeToString(v)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,32 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteCSV(t *testing.T) {
+	var records []DataRecord
+	for _, d := range readTestRecords(t) {
+		records = append(records, *d)
+	}
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, records, "ATTF"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	e := "ATTL,ATVL\n178,5\n147,20121113\n148,\"US,US,reprt,5thCGD,LNM 46/12\"\n"
+	if buf.String() != e {
+		t.Errorf("Expected %q, got %q", e, buf.String())
+	}
+	buf.Reset()
+	if err := WriteCSV(&buf, records, "FOID"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if e = "AGEN,FIDN,FIDS\n550,8734295,50\n"; buf.String() != e {
+		t.Errorf("Expected %q, got %q", e, buf.String())
+	}
+}
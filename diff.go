@@ -0,0 +1,103 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"bytes"
+	"reflect"
+)
+
+// FieldDiff is a field that differs between two records. A is nil for a
+// field only in the second record and B for one only in the first.
+type FieldDiff struct {
+	Tag string
+	// Index counts the fields with the Tag before this one, for records
+	// that repeat a field.
+	Index     int
+	A, B      *Field
+	SubFields []SubFieldDiff
+}
+
+// SubFieldDiff is a subfield value that differs between two fields. A
+// value missing from the shorter field is nil.
+type SubFieldDiff struct {
+	Tag   string
+	Index int // The position in SubFields.
+	A, B  interface{}
+}
+
+// DiffRecords compares two records field by field and returns the fields
+// that differ, in the order of a and then of the fields only in b.
+// Fields are matched by tag and, when a tag repeats, by occurrence, and
+// their SubFields compared by position, or their Raw data when they are
+// unresolved.
+func DiffRecords(a, b *DataRecord) []FieldDiff {
+	type key struct {
+		tag   string
+		index int
+	}
+	others := make(map[key]*Field)
+	keys := make([]key, len(b.Fields))
+	seen := make(map[string]int)
+	for i := range b.Fields {
+		f := &b.Fields[i]
+		keys[i] = key{f.Tag, seen[f.Tag]}
+		others[keys[i]] = f
+		seen[f.Tag]++
+	}
+	var diffs []FieldDiff
+	seen = make(map[string]int)
+	for i := range a.Fields {
+		f := &a.Fields[i]
+		k := key{f.Tag, seen[f.Tag]}
+		seen[f.Tag]++
+		other, ok := others[k]
+		if !ok {
+			diffs = append(diffs, FieldDiff{Tag: f.Tag, Index: k.index, A: f})
+			continue
+		}
+		delete(others, k)
+		sub := diffSubFields(f, other)
+		// Unresolved fields have only their Raw data to compare.
+		if len(sub) > 0 || (!f.Resolved() && !bytes.Equal(f.Raw, other.Raw)) {
+			diffs = append(diffs, FieldDiff{Tag: f.Tag, Index: k.index, A: f, B: other, SubFields: sub})
+		}
+	}
+	for i, k := range keys {
+		if _, ok := others[k]; ok {
+			diffs = append(diffs, FieldDiff{Tag: k.tag, Index: k.index, B: &b.Fields[i]})
+		}
+	}
+	return diffs
+}
+
+// diffSubFields compares the SubFields of two fields by position.
+func diffSubFields(a, b *Field) []SubFieldDiff {
+	types := a.FieldType.Format()
+	n := len(a.SubFields)
+	if len(b.SubFields) > n {
+		n = len(b.SubFields)
+		types = b.FieldType.Format()
+	}
+	var diffs []SubFieldDiff
+	for i := 0; i < n; i++ {
+		var va, vb interface{}
+		if i < len(a.SubFields) {
+			va = a.SubFields[i]
+		}
+		if i < len(b.SubFields) {
+			vb = b.SubFields[i]
+		}
+		if reflect.DeepEqual(va, vb) {
+			continue
+		}
+		var tag string
+		if len(types) > 0 {
+			tag = string(types[i%len(types)].Tag)
+		}
+		diffs = append(diffs, SubFieldDiff{tag, i, va, vb})
+	}
+	return diffs
+}
@@ -0,0 +1,51 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffRecords(t *testing.T) {
+	a := readTestRecords(t)[1]
+	if d := DiffRecords(a, a); len(d) != 0 {
+		t.Error("Expected no differences, got ", d)
+	}
+	b := a.Clone()
+	frid, _ := b.Field("FRID")
+	setSubField(frid, "RVER", uint16(3))
+	attf, _ := b.Field("ATTF")
+	attf.SubFields = attf.SubFields[:4]
+	b.Fields = append(b.Fields, Field{Tag: "NATF", FieldType: attf.FieldType, SubFields: []interface{}{uint16(301), "x"}})
+	b.Fields = append(b.Fields[:2], b.Fields[3:]...) // Remove the FOID.
+	diffs := DiffRecords(a, b)
+	if len(diffs) != 4 {
+		t.Fatal("Expected 4 differences, got ", diffs)
+	}
+	e := []SubFieldDiff{{"RVER", 5, uint16(2), uint16(3)}}
+	if diffs[0].Tag != "FRID" || !reflect.DeepEqual(diffs[0].SubFields, e) {
+		t.Error("Expected ", e, ", got ", diffs[0])
+	}
+	if diffs[1].Tag != "FOID" || diffs[1].A == nil || diffs[1].B != nil {
+		t.Error("Expected FOID only in a, got ", diffs[1])
+	}
+	e = []SubFieldDiff{{"ATTL", 4, uint16(148), nil}, {"ATVL", 5, "US,US,reprt,5thCGD,LNM 46/12", nil}}
+	if diffs[2].Tag != "ATTF" || !reflect.DeepEqual(diffs[2].SubFields, e) {
+		t.Error("Expected ", e, ", got ", diffs[2])
+	}
+	if diffs[3].Tag != "NATF" || diffs[3].A != nil || diffs[3].B == nil {
+		t.Error("Expected NATF only in b, got ", diffs[3])
+	}
+}
+
+func TestDiffRecordsRepeatedAndRaw(t *testing.T) {
+	a := &DataRecord{Fields: []Field{{Tag: "X", Raw: []byte("1\x1e")}, {Tag: "X", Raw: []byte("2\x1e")}}}
+	b := &DataRecord{Fields: []Field{{Tag: "X", Raw: []byte("1\x1e")}, {Tag: "X", Raw: []byte("3\x1e")}}}
+	diffs := DiffRecords(a, b)
+	if len(diffs) != 1 || diffs[0].Index != 1 || string(diffs[0].B.Raw) != "3\x1e" {
+		t.Error("Expected the second X to differ, got ", diffs)
+	}
+}
@@ -0,0 +1,532 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+)
+
+// RecordKind is the kind of record given by a leader identifier.
+type RecordKind int
+
+const (
+	UnknownRecordKind RecordKind = iota // Not an ISO 8211 leader identifier.
+	LeadRecordKind                      // L, a lead record (DDR).
+	DataRecordKind                      // D, a data record.
+)
+
+// PeekRecordKind reports the kind of the next record of r, from the
+// leader identifier, without consuming any bytes, so the caller can read
+// it with LeadRecord.Read or DataRecord.Read. A data record whose leader
+// and directory the records after it reuse, R, cannot be read and is an
+// UnknownRecordKind. It returns io.EOF when r has no more records.
+func PeekRecordKind(r *bufio.Reader) (RecordKind, error) {
+	b, err := r.Peek(7)
+	if err == io.EOF && len(b) > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return UnknownRecordKind, err
+	}
+	switch b[6] {
+	case 'L':
+		return LeadRecordKind, nil
+	case 'D':
+		return DataRecordKind, nil
+	}
+	return UnknownRecordKind, nil
+}
+
+// File reads the data records of an ISO 8211 file in turn. A lead
+// record after the first, as in datasets concatenated into one stream,
+// replaces the lead record of the data records that follow it, see
+// ActiveLead.
+type File struct {
+	// Lead is the first lead record of the file.
+	Lead LeadRecord
+	// Strict, KeepRaw, Lazy, DecodeTags and Limits are the settings of
+	// the data records read.
+	Strict     bool
+	KeepRaw    bool
+	Lazy       bool
+	DecodeTags map[string]bool
+	Limits     Limits
+	// Recover makes Next skip the bytes of a data record that cannot be
+	// read, scanning a byte at a time for the next well formed leader and
+	// directory, so a damaged file can be salvaged. Stats counts the
+	// bytes skipped.
+	Recover bool
+	r       *countingReader
+	br      *bufio.Reader
+	lead    *LeadRecord
+	records int
+	skipped int64
+}
+
+// Stats counts the progress of a File.
+type Stats struct {
+	Records int   // The data records read or skipped.
+	Bytes   int64 // The bytes consumed, including the lead record.
+	Skipped int64 // The bytes of damaged records skipped by Recover.
+}
+
+// Stats returns the number of data records and bytes the File has
+// consumed, e.g. for progress reporting or to confirm that a whole file
+// was read. Records that failed to read are not counted, their bytes
+// are.
+func (f *File) Stats() Stats {
+	return Stats{Records: f.records, Bytes: f.r.n, Skipped: f.skipped}
+}
+
+// countingReader counts the bytes read, the offset of the next record.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// NewReader reads the lead record from r and returns a File ready to
+// read the data records that follow.
+func NewReader(r io.Reader) (*File, error) {
+	return NewReaderLimits(r, Limits{})
+}
+
+// NewReaderLimits is NewReader for files that are not trusted: the lead
+// record and the data records read must be within the limits.
+func NewReaderLimits(r io.Reader, limits Limits) (*File, error) {
+	return newReader(r, limits, Terminators{})
+}
+
+// NewReaderTerminators is NewReader for files written with other
+// terminators than the standard ones. They become the Terminators of the
+// Lead and of the lead records after it.
+func NewReaderTerminators(r io.Reader, t Terminators) (*File, error) {
+	return newReader(r, Limits{}, t)
+}
+
+func newReader(r io.Reader, limits Limits, t Terminators) (*File, error) {
+	f := &File{r: &countingReader{r: r}, Limits: limits}
+	f.Lead.Limits = limits
+	f.Lead.Terminators = t
+	if err := f.Lead.Read(f.r); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ActiveLead returns the lead record of the data records Next reads:
+// the Lead of the File until another lead record is read.
+func (f *File) ActiveLead() *LeadRecord {
+	if f.lead == nil {
+		return &f.Lead
+	}
+	return f.lead
+}
+
+// Next reads the next data record, with its Lead set to the active lead
+// record and its Header.Offset and Field offsets counted from the start
+// of the File. A lead record before it is read and becomes the active
+// one. It returns io.EOF, unwrapped, when the file ends cleanly after the
+// last record, and an error wrapping io.ErrUnexpectedEOF, with the offset
+// of the record, when it ends part way through one.
+func (f *File) Next() (*DataRecord, error) {
+	if f.Recover {
+		return f.nextRecover()
+	}
+	for {
+		header := Header{Offset: f.r.n}
+		if err := header.Read(f.r); err != nil {
+			return nil, err
+		}
+		if header.LeaderID == 'L' {
+			if err := f.readLead(header); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		d := f.newRecord()
+		d.Header = header
+		if err := d.readBody(f.r); err != nil {
+			return nil, err
+		}
+		f.records++
+		f.setLexicalLevels(d)
+		return d, nil
+	}
+}
+
+// newRecord returns a DataRecord with the File's settings, at the current
+// offset.
+func (f *File) newRecord() *DataRecord {
+	d := &DataRecord{Lead: f.ActiveLead(), Strict: f.Strict, KeepRaw: f.KeepRaw, Lazy: f.Lazy, DecodeTags: f.DecodeTags,
+		Limits: f.Limits}
+	d.Header.Offset = f.r.n
+	return d
+}
+
+// newLead returns a LeadRecord for a lead record after the first, with
+// its header read and the settings of the first.
+func (f *File) newLead(header Header) *LeadRecord {
+	return &LeadRecord{Header: header, Strict: f.Lead.Strict, Limits: f.Lead.Limits, Terminators: f.Lead.Terminators}
+}
+
+// readLead reads the rest of a lead record after the first and makes it
+// the active one. The data records read before keep theirs.
+func (f *File) readLead(header Header) error {
+	lead := f.newLead(header)
+	if err := lead.readBody(f.r); err != nil {
+		return err
+	}
+	f.lead = lead
+	return nil
+}
+
+// nextRecover is Next for Recover, skipping a byte at a time until a
+// record can be read. It returns io.EOF at the end of the file, and the
+// error of the reader when it fails.
+func (f *File) nextRecover() (*DataRecord, error) {
+	if f.br == nil {
+		// Buffer enough to peek at any record, the record length has 5
+		// digits.
+		f.br = bufio.NewReaderSize(f.r.r, 100000)
+		f.r.r = f.br
+	}
+	for {
+		d, n, err := f.peekRecord()
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			f.br.Discard(n)
+			f.r.n += int64(n)
+			if d == nil {
+				// A lead record, now the active one.
+				continue
+			}
+			f.records++
+			f.setLexicalLevels(d)
+			return d, nil
+		}
+		if f.br.Buffered() == 0 {
+			return nil, io.EOF
+		}
+		f.br.Discard(1)
+		f.r.n++
+		f.skipped++
+	}
+}
+
+// peekRecord reads the record at the start of the buffer without
+// consuming it, and returns it and its length. The leader and directory
+// must be valid, see Header.Validate. A lead record is read, and made the
+// active one, with a nil DataRecord. The length is zero when the bytes
+// are not a record, or are cut short by the end of the file; the error is
+// that of the reader.
+func (f *File) peekRecord() (*DataRecord, int, error) {
+	peek := func(n int) ([]byte, error) {
+		b, err := f.br.Peek(n)
+		if err == io.EOF || err == bufio.ErrBufferFull {
+			// Too few bytes for a record.
+			err = nil
+		}
+		return b, err
+	}
+	leader, err := peek(binary.Size(RawHeader{}))
+	if err != nil || len(leader) < binary.Size(RawHeader{}) {
+		return nil, 0, err
+	}
+	if leader[6] != 'D' && leader[6] != 'L' {
+		return nil, 0, nil
+	}
+	base, err := strconv.Atoi(string(leader[12:17]))
+	if err != nil || base < 0 {
+		return nil, 0, nil
+	}
+	b, err := peek(base)
+	if err != nil || len(b) < base {
+		return nil, 0, err
+	}
+	header := Header{Offset: f.r.n}
+	if header.Read(bytes.NewReader(b)) != nil || header.Validate() != nil || f.Limits.check(&header) != nil {
+		return nil, 0, nil
+	}
+	n := int(header.RecordLength)
+	if b, err = peek(n); err != nil || len(b) < n {
+		return nil, 0, err
+	}
+	if header.LeaderID == 'L' {
+		lead := f.newLead(header)
+		if lead.readBody(bytes.NewReader(b[header.BaseAddress:])) != nil {
+			return nil, 0, nil
+		}
+		f.lead = lead
+		return nil, n, nil
+	}
+	d := f.newRecord()
+	// Hide the Seek method, the offset is the File's.
+	if d.Read(struct{ io.Reader }{bytes.NewReader(b)}) != nil {
+		return nil, 0, nil
+	}
+	return d, n, nil
+}
+
+// NextContext is Next, but returns the error of ctx instead of reading
+// the record when ctx is done.
+func (f *File) NextContext(ctx context.Context) (*DataRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.Next()
+}
+
+// Skip advances past the next n data records without reading their
+// fields, using the record length of each leader, or of the directory
+// when the leader leaves it blank. The S-57 DSSI field is read, so the
+// lexical levels it sets apply as they do for Next. The fields of each
+// record are skipped with Seek when the File's reader is an io.Seeker,
+// otherwise they are read and discarded. It returns io.EOF when there
+// are fewer than n records left and, as Next does, io.ErrUnexpectedEOF
+// when the file ends within a record.
+func (f *File) Skip(n int) error {
+	for i := 0; i < n; i++ {
+		if _, err := f.skipRecord(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TagCounts reads the leader and directory of each remaining data record,
+// skipping the field data as Skip does, and counts the fields with each
+// tag.
+func (f *File) TagCounts() (map[string]int, error) {
+	counts := make(map[string]int)
+	for {
+		header, err := f.skipRecord()
+		if err == io.EOF {
+			return counts, nil
+		}
+		if err != nil {
+			return counts, err
+		}
+		for _, e := range header.Entries {
+			counts[string(e.Tag)]++
+		}
+	}
+}
+
+// skipRecord reads the leader and directory of the next data record and
+// skips its fields. A lead record before it is read, as Next does, and so
+// is the DSSI field of a record that has one, for its lexical levels.
+func (f *File) skipRecord() (*Header, error) {
+	header := &Header{Offset: f.r.n}
+	if err := header.Read(f.r); err != nil {
+		return nil, err
+	}
+	if header.LeaderID == 'L' {
+		if err := f.readLead(*header); err != nil {
+			return nil, err
+		}
+		return f.skipRecord()
+	}
+	for _, e := range header.Entries {
+		if string(e.Tag) != "DSSI" {
+			continue
+		}
+		d := f.newRecord()
+		d.Header, d.Lazy, d.DecodeTags = *header, false, map[string]bool{"DSSI": true}
+		if err := d.readBody(f.r); err != nil {
+			return nil, err
+		}
+		f.records++
+		f.setLexicalLevels(d)
+		return &d.Header, nil
+	}
+	if header.RecordLength < header.BaseAddress {
+		return nil, fmt.Errorf("record at offset %d: record length %d is less than the base address %d",
+			header.Offset, header.RecordLength, header.BaseAddress)
+	}
+	if err := f.discard(int64(header.RecordLength - header.BaseAddress)); err != nil {
+		return nil, fmt.Errorf("record at offset %d: fields: %w", header.Offset, err)
+	}
+	f.records++
+	return header, nil
+}
+
+// discard skips n bytes, seeking if the reader is an io.Seeker. It
+// returns io.ErrUnexpectedEOF if the file ends first.
+func (f *File) discard(n int64) error {
+	if seeker, ok := f.r.r.(io.Seeker); ok && n > 0 {
+		// Seeking past the end is not an error, so read the last byte.
+		if _, err := seeker.Seek(n-1, io.SeekCurrent); err != nil {
+			return err
+		}
+		f.r.n += n - 1
+		_, err := io.ReadFull(f.r, make([]byte, 1))
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	_, err := io.CopyN(ioutil.Discard, f.r, n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// setLexicalLevels applies the attribute lexical levels of an S-57 DSSI
+// field to the attribute fields of the records that follow it.
+func (f *File) setLexicalLevels(d *DataRecord) {
+	dssi, ok := d.Field("DSSI")
+	if !ok {
+		return
+	}
+	if dssi.SubFields == nil && dssi.Raw != nil {
+		// Decode a Lazy DSSI without changing the record.
+		decoded := *dssi
+		decoded.SubFields = dssi.FieldType.Decode(dssi.FieldType.trimTerminator(dssi.Raw))
+		dssi = &decoded
+	}
+	v, _ := dssi.SubField("AALL")
+	if aall, ok := v.(uint8); ok {
+		f.ActiveLead().SetLexicalLevel(int(aall), "ATTF", "ATTV")
+	}
+	v, _ = dssi.SubField("NALL")
+	if nall, ok := v.(uint8); ok {
+		f.ActiveLead().SetLexicalLevel(int(nall), "NATF")
+	}
+}
+
+// ReadAll reads the lead record and every data record of r, with each
+// record's Lead set to the returned lead record, or to the lead record
+// before it when r has more than one. Read with NewReader and
+// File.Next to report progress with File.Stats.
+func ReadAll(r io.Reader) (*LeadRecord, []DataRecord, error) {
+	return ReadAllContext(context.Background(), r)
+}
+
+// ReadAllContext is ReadAll, but stops between records when ctx is done,
+// returning the records read so far and the error of ctx.
+func ReadAllContext(ctx context.Context, r io.Reader) (*LeadRecord, []DataRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	f, err := NewReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	var records []DataRecord
+	for {
+		d, err := f.NextContext(ctx)
+		if err == io.EOF {
+			return &f.Lead, records, nil
+		}
+		if err != nil {
+			return &f.Lead, records, err
+		}
+		records = append(records, *d)
+	}
+}
+
+// FileAt reads the data records of an ISO 8211 file by offset, e.g. from
+// a memory mapped file, so they can be read lazily and in any order. The
+// lexical levels of an S-57 DSSI field are not applied as File applies
+// them; use LeadRecord.SetLexicalLevel.
+type FileAt struct {
+	Lead LeadRecord
+	// Strict, KeepRaw, Lazy, DecodeTags and Limits are the settings of
+	// the data records read.
+	Strict     bool
+	KeepRaw    bool
+	Lazy       bool
+	DecodeTags map[string]bool
+	Limits     Limits
+	r          io.ReaderAt
+	size       int64
+	first      int64
+}
+
+// NewFileAt reads the lead record of the size byte file r.
+func NewFileAt(r io.ReaderAt, size int64) (*FileAt, error) {
+	f := &FileAt{r: r, size: size}
+	// No record or field is larger than the file.
+	f.Lead.Limits = f.limits()
+	if err := f.Lead.Read(io.NewSectionReader(r, 0, size)); err != nil {
+		return nil, err
+	}
+	f.first = int64(f.Lead.Header.RecordLength)
+	return f, nil
+}
+
+// Offsets returns the offset of each data record, read from the record
+// length in each leader, or from the directory when it is blank.
+func (f *FileAt) Offsets() ([]int64, error) {
+	var offsets []int64
+	var ddr RawHeader
+	size := int64(binary.Size(ddr))
+	for offset := f.first; offset < f.size; {
+		b := make([]byte, size)
+		if _, err := f.r.ReadAt(b, offset); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return offsets, fmt.Errorf("record at offset %d: leader: %w", offset, err)
+		}
+		length, err := strconv.ParseUint(string(b[:5]), 10, 64)
+		if string(b[:5]) == "     " {
+			// A blank record length is given by the directory.
+			header := Header{Offset: offset}
+			if err = header.Read(io.NewSectionReader(f.r, offset, f.size-offset)); err != nil {
+				return offsets, err
+			}
+			length = header.RecordLength
+		}
+		if err != nil || int64(length) < size {
+			return offsets, fmt.Errorf("record at offset %d: record length %q is not valid", offset, b[:5])
+		}
+		offsets = append(offsets, offset)
+		offset += int64(length)
+	}
+	return offsets, nil
+}
+
+// limits returns the Limits, lowered to the size of the file.
+func (f *FileAt) limits() Limits {
+	limits := f.Limits
+	if limits.MaxRecordLength == 0 || limits.MaxRecordLength > uint64(f.size) {
+		limits.MaxRecordLength = uint64(f.size)
+	}
+	if limits.MaxFieldLength == 0 || int64(limits.MaxFieldLength) > f.size {
+		limits.MaxFieldLength = int(f.size)
+	}
+	return limits
+}
+
+// RecordAt reads the data record at offset, with its Lead set to the
+// FileAt's lead record.
+func (f *FileAt) RecordAt(offset int64) (*DataRecord, error) {
+	d := &DataRecord{Lead: &f.Lead, Strict: f.Strict, KeepRaw: f.KeepRaw, Lazy: f.Lazy, DecodeTags: f.DecodeTags,
+		Limits: f.limits()}
+	d.Header.Offset = offset
+	// Hide the Seek method of the section, its offsets are relative.
+	r := struct{ io.Reader }{io.NewSectionReader(f.r, offset, f.size-offset)}
+	if err := d.Read(r); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
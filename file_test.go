@@ -0,0 +1,567 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+)
+
+func TestFile(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	f, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	n := 0
+	for {
+		d, err := f.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		n++
+		if d.Lead != &f.Lead || d.Fields[0].SubFields[0] != uint16(n) {
+			t.Error("Data record ", n, " is not what we expected.")
+		}
+	}
+	if n != 2 {
+		t.Error("Expected 2 records, got ", n)
+	}
+	if l := f.Lead.FieldTypes["NATF"].LexicalLevel; l != 1 {
+		t.Error("Expected the DSSI NALL lexical level 1, got ", l)
+	}
+	// A cell with only a DDR has no records.
+	f, err = NewReader(bytes.NewReader(data[:1814]))
+	if err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	if _, err = f.Next(); err != io.EOF {
+		t.Error("Expected io.EOF, got ", err)
+	}
+}
+
+func TestFileOffsets(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	_, records, err := ReadAll(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if records[0].Header.Offset != 1814 {
+		t.Error("Expected the first record at 1814, got ", records[0].Header.Offset)
+	}
+	for _, d := range records {
+		for _, field := range d.Fields {
+			if data[field.Offset+int64(field.Length)-1] != '\x1e' {
+				t.Error("Field ", field.Tag, " does not end at its offset and length")
+			}
+		}
+	}
+	// A seekable file sets the offset without a File.
+	r := bytes.NewReader(data)
+	r.Seek(int64(records[1].Header.Offset), io.SeekStart)
+	d := DataRecord{Lead: records[1].Lead}
+	if err = d.Read(r); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if d.Header.Offset != records[1].Header.Offset || d.Fields[3].Offset != records[1].Fields[3].Offset {
+		t.Error("Expected the offsets of the second record, got ", d.Header.Offset, d.Fields[3].Offset)
+	}
+}
+
+func TestFileSkip(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	// A bytes.Reader seeks, the OneByteReader does not.
+	for _, r := range []io.Reader{bytes.NewReader(data), iotest.OneByteReader(bytes.NewReader(data))} {
+		f, err := NewReader(r)
+		if err != nil {
+			t.Fatal("Error reading the lead record: ", err)
+		}
+		f.Lead.SetLexicalLevel(0, "NATF")
+		if err = f.Skip(1); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		d, err := f.Next()
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		if d.Fields[0].SubFields[0] != uint16(2) || d.Header.Offset != int64(len(data))-int64(d.Header.RecordLength) {
+			t.Error("Expected the second record, got ", d)
+		}
+		if err = f.Skip(1); err != io.EOF {
+			t.Error("Expected io.EOF, got ", err)
+		}
+		if l := f.Lead.FieldTypes["NATF"].LexicalLevel; l != 1 {
+			t.Error("Expected the DSSI NALL lexical level 1, got ", l)
+		}
+	}
+}
+
+func TestFileStats(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	f, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	if s := f.Stats(); s != (Stats{Records: 0, Bytes: 1814}) {
+		t.Error("Expected the lead record only, got ", s)
+	}
+	if err = f.Skip(1); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if _, err = f.Next(); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if _, err = f.Next(); err != io.EOF {
+		t.Error("Expected io.EOF, got ", err)
+	}
+	if s := f.Stats(); s != (Stats{Records: 2, Bytes: int64(len(data))}) {
+		t.Error("Expected 2 records and ", len(data), " bytes, got ", s)
+	}
+}
+
+func TestFileTruncated(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	second := 1814 + 144
+	for n := 1815; n <= len(data); n++ {
+		for _, skip := range []bool{false, true} {
+			f, err := NewReader(bytes.NewReader(data[:n]))
+			if err != nil {
+				t.Fatal("Error reading the lead record: ", err)
+			}
+			for err == nil {
+				if skip {
+					err = f.Skip(1)
+				} else {
+					_, err = f.Next()
+				}
+			}
+			if n == second || n == len(data) {
+				if err != io.EOF {
+					t.Error("At ", n, " expected io.EOF, got ", err)
+				}
+			} else if !errors.Is(err, io.ErrUnexpectedEOF) || !strings.Contains(err.Error(), " at offset ") {
+				t.Error("At ", n, " expected a wrapped io.ErrUnexpectedEOF, got ", err)
+			}
+		}
+	}
+}
+
+func TestFileTagCounts(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	for _, r := range []io.Reader{bytes.NewReader(data), iotest.OneByteReader(bytes.NewReader(data))} {
+		f, err := NewReader(r)
+		if err != nil {
+			t.Fatal("Error reading the lead record: ", err)
+		}
+		tags := f.Lead.Tags()
+		if len(tags) != 19 || tags[0] != "0000" || tags[18] != "VRPT" {
+			t.Error("Unexpected lead record tags ", tags)
+		}
+		counts, err := f.TagCounts()
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		e := map[string]int{"0001": 2, "DSID": 1, "DSSI": 1, "FRID": 1, "FOID": 1, "ATTF": 1}
+		if !reflect.DeepEqual(counts, e) {
+			t.Error("Expected ", e, ", got ", counts)
+		}
+	}
+}
+
+func TestFileLimits(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	// A data record with a blank record length and a 1GB field.
+	h := Header{InterchangeLevel: ' ', LeaderID: 'D', LengthSize: 9, PositionSize: 1, TagSize: 4,
+		BaseAddress: 39, Entries: []DirEntry{{[]byte("0001"), 999999999, 0}}}
+	huge, err := h.RawBytes()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	file := append(append([]byte{}, data[:1814]...), huge...)
+	limits := Limits{MaxRecordLength: 1 << 20, MaxFieldLength: 1 << 16}
+	f, err := NewReaderLimits(bytes.NewReader(file), limits)
+	if err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	if _, err = f.Next(); err == nil || !strings.Contains(err.Error(), "over the limit") {
+		t.Error("Expected a limit error, got ", err)
+	}
+	fa, err := NewFileAt(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	if _, err = fa.RecordAt(1814); err == nil || !strings.Contains(err.Error(), "over the limit") {
+		t.Error("Expected a limit error, got ", err)
+	}
+	if _, err = NewReaderLimits(bytes.NewReader(data), Limits{MaxRecordLength: 1000}); err == nil {
+		t.Error("Expected an error for the 1814 byte lead record")
+	}
+	// A small record length with large fields in the directory.
+	h.RecordLength = 53
+	h.Entries = []DirEntry{{[]byte("0001"), 500000000, 0}, {[]byte("FRID"), 500000000, 500000000}}
+	h.PositionSize = 9
+	h.BaseAddress = 24 + 2*22 + 1
+	if huge, err = h.RawBytes(); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	file = append(append([]byte{}, data[:1814]...), huge...)
+	if f, err = NewReader(bytes.NewReader(file)); err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	f.Limits = Limits{MaxRecordLength: 100}
+	if _, err = f.Next(); err == nil || !strings.Contains(err.Error(), "directory length") {
+		t.Error("Expected a limit error, got ", err)
+	}
+	if err = (Limits{}).check(&Header{Entries: []DirEntry{{[]byte("0001"), -1, 0}}}); err == nil ||
+		!strings.Contains(err.Error(), "negative") {
+		t.Error("Expected a negative length error, got ", err)
+	}
+}
+
+func TestFileAt(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	f, err := NewFileAt(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	offsets, err := f.Offsets()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if len(offsets) != 2 || offsets[0] != 1814 {
+		t.Fatal("Unexpected offsets ", offsets)
+	}
+	// Read the records out of order.
+	for i := len(offsets) - 1; i >= 0; i-- {
+		d, err := f.RecordAt(offsets[i])
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		if d.Fields[0].SubFields[0] != uint16(i+1) || d.Header.Offset != offsets[i] || d.Lead != &f.Lead {
+			t.Error("Record ", i+1, " is not what we expected: ", d)
+		}
+	}
+	if _, err = f.RecordAt(int64(len(data))); err != io.EOF {
+		t.Error("Expected io.EOF, got ", err)
+	}
+	f, _ = NewFileAt(bytes.NewReader(data[:len(data)-10]), int64(len(data)-10))
+	if _, err = f.RecordAt(offsets[1]); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Error("Expected io.ErrUnexpectedEOF, got ", err)
+	}
+}
+
+func TestReadAll(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	lead, records, err := ReadAll(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if len(records) != 2 {
+		t.Fatal("Expected 2 records, got ", len(records))
+	}
+	for _, d := range records {
+		if d.Lead != lead {
+			t.Error("Expected the records to share the lead record")
+		}
+	}
+	_, records, err = ReadAll(bytes.NewReader(data[:len(data)-10]))
+	if !errors.Is(err, io.ErrUnexpectedEOF) || len(records) != 1 {
+		t.Error("Expected 1 record and io.ErrUnexpectedEOF, got ", len(records), err)
+	}
+}
+
+func TestReadAllContext(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	f, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	if _, err = f.NextContext(ctx); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	cancel()
+	if _, err = f.NextContext(ctx); err != context.Canceled {
+		t.Error("Expected context.Canceled, got ", err)
+	}
+	if _, _, err = ReadAllContext(ctx, bytes.NewReader(data)); err != context.Canceled {
+		t.Error("Expected context.Canceled, got ", err)
+	}
+}
+
+func TestPeekRecordKind(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	r := bufio.NewReader(bytes.NewReader(data))
+	var kinds []RecordKind
+	var l LeadRecord
+	for {
+		kind, err := PeekRecordKind(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		kinds = append(kinds, kind)
+		switch kind {
+		case LeadRecordKind:
+			err = l.Read(r)
+		case DataRecordKind:
+			d := DataRecord{Lead: &l}
+			err = d.Read(r)
+		}
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+	}
+	e := []RecordKind{LeadRecordKind, DataRecordKind, DataRecordKind}
+	if !reflect.DeepEqual(kinds, e) {
+		t.Error("Expected ", e, ", got ", kinds)
+	}
+	if _, err = PeekRecordKind(bufio.NewReader(bytes.NewReader(data[:3]))); err != io.ErrUnexpectedEOF {
+		t.Error("Expected io.ErrUnexpectedEOF, got ", err)
+	}
+	// The leader identifier R, a leader the records after it reuse.
+	repeating := append([]byte{}, data[1814:1838]...)
+	repeating[6] = 'R'
+	if kind, err := PeekRecordKind(bufio.NewReader(bytes.NewReader(repeating))); kind != UnknownRecordKind || err != nil {
+		t.Error("Expected an unknown record kind, got ", kind, err)
+	}
+}
+
+func TestFileLazyWorkers(t *testing.T) {
+	e := readTestRecords(t)
+	f, err := os.Open("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	defer f.Close()
+	file, err := NewReader(f)
+	if err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	file.Lazy = true
+	frames := make(chan *DataRecord)
+	decoded := make([]*DataRecord, 0, len(e))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range frames {
+				if err := d.Decode(); err != nil {
+					t.Error("Unexpected error: ", err)
+				}
+				mu.Lock()
+				decoded = append(decoded, d)
+				mu.Unlock()
+			}
+		}()
+	}
+	for d, err := file.Next(); err == nil; d, err = file.Next() {
+		frames <- d
+	}
+	close(frames)
+	wg.Wait()
+	sort.Slice(decoded, func(i, j int) bool { return decoded[i].Header.Offset < decoded[j].Header.Offset })
+	for i, d := range decoded {
+		if d.String() != e[i].String() || d.Fields[1].Raw != nil {
+			t.Error("Expected ", e[i], ", got ", d)
+		}
+	}
+}
+
+func ExampleFile() {
+	r, err := os.Open("testdata/US5MD12M.001")
+	if err != nil {
+		fmt.Println("No file. ", err)
+		return
+	}
+	defer r.Close()
+	f, err := NewReader(r)
+	if err != nil {
+		fmt.Println("Bad lead record. ", err)
+		return
+	}
+	for d, err := f.Next(); err == nil; d, err = f.Next() {
+		fmt.Println(d.Fields[1].Tag)
+	}
+	// Output:
+	// DSID
+	// FRID
+}
+
+func TestFileRecover(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	// Garbage between the two data records and a truncated copy of the
+	// first one at the end.
+	var damaged []byte
+	damaged = append(damaged, data[:1958]...)
+	damaged = append(damaged, "garbage D 00024"...)
+	damaged = append(damaged, data[1958:]...)
+	damaged = append(damaged, data[1814:1900]...)
+	f, err := NewReader(bytes.NewReader(damaged))
+	if err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	if _, err = f.Next(); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if _, err = f.Next(); err == nil {
+		t.Fatal("Expected an error for the garbage")
+	}
+
+	f, err = NewReader(bytes.NewReader(damaged))
+	if err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	f.Recover = true
+	var offsets []int64
+	for {
+		d, err := f.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		offsets = append(offsets, d.Header.Offset)
+	}
+	if len(offsets) != 2 || offsets[0] != 1814 || offsets[1] != 1958+15 {
+		t.Error("Expected records at 1814 and 1973, got ", offsets)
+	}
+	e := Stats{Records: 2, Bytes: int64(len(damaged)), Skipped: 15 + 86}
+	if s := f.Stats(); s != e {
+		t.Error("Expected ", e, ", got ", s)
+	}
+
+	// A failing reader is not skipped over.
+	boom := errors.New("boom")
+	f, err = NewReader(io.MultiReader(bytes.NewReader(damaged), errReader{boom}))
+	if err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	f.Recover = true
+	for i := 0; err == nil && i < 10; i++ {
+		_, err = f.Next()
+	}
+	if err != boom {
+		t.Error("Expected ", boom, ", got ", err)
+	}
+}
+
+// errReader fails every read with err.
+type errReader struct{ err error }
+
+func (r errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+func TestFileMultipleLeads(t *testing.T) {
+	first, err := NewBuilder().Define("TEST", "Test", "NAME!NUMB", "(A,I(3))").Record("TEST", "a", 1).Bytes()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	second, err := NewBuilder().Define("TEST", "Test", "NUMB!NAME", "(I(2),A)").Record("TEST", 2, "b").Bytes()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	data := append(append([]byte{}, first...), second...)
+	for _, recovering := range []bool{false, true} {
+		f, err := NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal("Error reading the lead record: ", err)
+		}
+		f.Recover = recovering
+		var leads []*LeadRecord
+		var names []interface{}
+		for {
+			d, err := f.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+			if d.Lead != f.ActiveLead() {
+				t.Error("Expected the record to have the active lead record")
+			}
+			leads = append(leads, d.Lead)
+			name, _ := d.Fields[0].SubField("NAME")
+			names = append(names, name)
+		}
+		if len(leads) != 2 || leads[0] != &f.Lead || leads[1] == &f.Lead {
+			t.Error("Expected a second lead record, got ", leads)
+		}
+		if !reflect.DeepEqual(names, []interface{}{"a", "b"}) {
+			t.Error("Expected names a and b, got ", names)
+		}
+		if s := f.Stats(); s.Records != 2 || s.Bytes != int64(len(data)) || s.Skipped != 0 {
+			t.Error("Unexpected stats ", s)
+		}
+	}
+	f, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	if err = f.Skip(2); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if f.ActiveLead() == &f.Lead || string(f.ActiveLead().FieldTypes["TEST"].FormatControls) != "(I(2),A)" {
+		t.Error("Expected Skip to read the second lead record")
+	}
+}
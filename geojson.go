@@ -0,0 +1,39 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+type geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// GeoJSON encodes the SG2D and SG3D coordinates of a vector record as a
+// GeoJSON geometry, scaled as Coords does. A single position is a Point,
+// SG2D positions are a LineString and SG3D soundings a MultiPoint with the
+// depth as the third coordinate.
+func (data *DataRecord) GeoJSON(comf, somf uint32) ([]byte, error) {
+	coords, depths := data.Coords(comf, somf)
+	positions := make([][]float64, len(coords))
+	for i, c := range coords {
+		positions[i] = []float64{c.X, c.Y}
+		if len(depths) == len(coords) {
+			positions[i] = append(positions[i], depths[i])
+		}
+	}
+	switch {
+	case len(positions) == 0:
+		return nil, errors.New("record has no SG2D or SG3D coordinates")
+	case len(positions) == 1:
+		return json.Marshal(geometry{"Point", positions[0]})
+	case len(depths) > 0:
+		return json.Marshal(geometry{"MultiPoint", positions})
+	}
+	return json.Marshal(geometry{"LineString", positions})
+}
@@ -0,0 +1,34 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"testing"
+)
+
+func TestGeoJSON(t *testing.T) {
+	sg2d := FieldType{Tag: "SG2D", ArrayDescriptor: []byte("*YCOO!XCOO"), FormatControls: []byte("(2b24)")}
+	sg3d := FieldType{Tag: "SG3D", ArrayDescriptor: []byte("*YCOO!XCOO!VE3D"), FormatControls: []byte("(3b24)")}
+	for _, c := range []struct {
+		field    Field
+		expected string
+	}{
+		{Field{Tag: "SG2D", FieldType: sg2d, SubFields: []interface{}{int32(389500000), int32(-763000000)}},
+			`{"type":"Point","coordinates":[-76.3,38.95]}`},
+		{Field{Tag: "SG2D", FieldType: sg2d, SubFields: []interface{}{int32(389500000), int32(-763000000), int32(389600000), int32(-763100000)}},
+			`{"type":"LineString","coordinates":[[-76.3,38.95],[-76.31,38.96]]}`},
+		{Field{Tag: "SG3D", FieldType: sg3d, SubFields: []interface{}{int32(389500000), int32(-763000000), int32(125), int32(389600000), int32(-763100000), int32(30)}},
+			`{"type":"MultiPoint","coordinates":[[-76.3,38.95,12.5],[-76.31,38.96,3]]}`},
+	} {
+		d := DataRecord{Fields: []Field{c.field}}
+		b, err := d.GeoJSON(10000000, 10)
+		if err != nil || string(b) != c.expected {
+			t.Error("Expected ", c.expected, ", got ", string(b), err)
+		}
+	}
+	if _, err := readTestRecords(t)[1].GeoJSON(10000000, 10); err == nil {
+		t.Error("Expected an error for a record without coordinates")
+	}
+}
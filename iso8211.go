@@ -25,10 +25,26 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"math"
 	"reflect"
-	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+)
+
+// The errors Read wraps when a record is not what it expects, for
+// callers to test with errors.Is.
+var (
+	// ErrNotISO8211 is a leader that no ISO 8211 record has.
+	ErrNotISO8211 = errors.New("not an ISO 8211 file")
+	// ErrNotLeadRecord is an ISO 8211 record where a lead record should be.
+	ErrNotLeadRecord = errors.New("not a lead record")
+	// ErrNotDataRecord is an ISO 8211 record where a data record should be.
+	ErrNotDataRecord = errors.New("not a data record")
 )
 
 // RawHeader is a convenience for directly loading the on-disk
@@ -69,6 +85,9 @@ type Header struct {
 	ExtendedCharacterSetIndicator     []byte
 	LengthSize, PositionSize, TagSize int8
 	Entries                           []DirEntry
+	// Offset of the record in the file. DataRecord.Read sets it when the
+	// file is an io.Seeker, File.Next for any reader.
+	Offset int64
 }
 
 // LeadRecord is the first Record in a file. It has metadata for each
@@ -76,16 +95,77 @@ type Header struct {
 type LeadRecord struct {
 	Header     Header
 	FieldTypes map[string]FieldType
+	// TagPairs is the field hierarchy from the field control field, 0000,
+	// if the file has one.
+	TagPairs []TagPair
+	// Terminators of the file, given to each FieldType read.
+	Terminators Terminators
+	// Strict makes Read check that the record is well formed.
+	Strict bool
+	// Limits on the record read, none when zero.
+	Limits Limits
+}
+
+// Limits bound the size of the records and fields Read accepts, so a
+// corrupt or malicious leader cannot make it allocate more memory. Zero
+// is no limit. The leader and directory are always less than 100000
+// bytes, as the base address has 5 digits.
+type Limits struct {
+	MaxRecordLength uint64
+	MaxFieldLength  int
+}
+
+// check returns an error if the record of the header, as its leader
+// gives it or as its directory adds it up, is over the limits or has a
+// field of negative length.
+func (limits Limits) check(header *Header) error {
+	for _, d := range header.Entries {
+		if d.Length < 0 {
+			return fmt.Errorf("record at offset %d: field %s length %d is negative", header.Offset, d.Tag, d.Length)
+		}
+		if limits.MaxFieldLength > 0 && d.Length > limits.MaxFieldLength {
+			return fmt.Errorf("record at offset %d: field %s length %d is over the limit of %d",
+				header.Offset, d.Tag, d.Length, limits.MaxFieldLength)
+		}
+	}
+	if limits.MaxRecordLength > 0 && header.RecordLength > limits.MaxRecordLength {
+		return fmt.Errorf("record at offset %d: record length %d is over the limit of %d",
+			header.Offset, header.RecordLength, limits.MaxRecordLength)
+	}
+	if n := header.length(); limits.MaxRecordLength > 0 && n > limits.MaxRecordLength {
+		return fmt.Errorf("record at offset %d: directory length %d is over the limit of %d",
+			header.Offset, n, limits.MaxRecordLength)
+	}
+	return nil
+}
+
+// TagPair relates a field to a field nested within it.
+type TagPair struct {
+	Parent, Child string
 }
 
 // Field is a field within a data record, it holds an array of values 
 // called SubFields.
 type Field struct {
-	Tag       string
-	Length    int
-	Position  int
+	Tag      string
+	Length   int
+	Position int
+	// Offset of the field data in the file, when the Offset of the
+	// record is known.
+	Offset    int64
 	FieldType FieldType
 	SubFields []interface{}
+	// Raw is the field data as read, with its field terminator. It is
+	// kept for unresolved Fields, and for every Field of a DataRecord
+	// read with KeepRaw or Lazy.
+	Raw []byte
+}
+
+// RawField is the undecoded data of a Field that has no FieldType.
+type RawField struct {
+	Tag   string
+	Data  []byte   // The field data without the field terminator.
+	Units [][]byte // Data split at the unit terminators.
 }
 
 // DataRecord contains data for a set of Fields and their SubFields.
@@ -93,6 +173,23 @@ type DataRecord struct {
 	Header Header
 	Lead   *LeadRecord
 	Fields []Field
+	// Strict makes Read check that the record is well formed.
+	Strict bool
+	// Limits on the record read, none when zero.
+	Limits Limits
+	// KeepRaw makes Read keep the Raw data of every Field, not only of
+	// the unresolved ones.
+	KeepRaw bool
+	// Lazy makes Read keep the Raw data of every Field instead of
+	// decoding its SubFields, which EachSubField decodes as it goes and
+	// Decode decodes later, possibly on another goroutine.
+	Lazy bool
+	// DecodeTags, when not nil, makes Read decode only the Fields with a
+	// tag in it. The others are read past and left with nil SubFields,
+	// and nil Raw unless KeepRaw is set.
+	DecodeTags map[string]bool
+	// buf is reused for the field data that is not kept.
+	buf []byte
 }
 
 // RawFieldHeader is a convenience for loading the on-disk binary FieldType
@@ -106,11 +203,37 @@ type RawFieldHeader struct {
 	EscapeSeq         [3]byte
 }
 
+// Terminators are the field and unit terminator bytes. ISO 8211 fixes
+// them at 0x1e and 0x1f, which a zero value stands for, but a few
+// producers use others.
+type Terminators struct {
+	Field, Unit byte
+}
+
+// field returns the field terminator.
+func (t Terminators) field() byte {
+	if t.Field == 0 {
+		return '\x1e'
+	}
+	return t.Field
+}
+
+// unit returns the unit terminator.
+func (t Terminators) unit() byte {
+	if t.Unit == 0 {
+		return '\x1f'
+	}
+	return t.Unit
+}
+
 // SubFieldType holds the Go type, size and tag for each SubField.
 type SubFieldType struct {
 	Kind reflect.Kind
 	Size int
 	Tag  []byte
+	// Binary is set for the binary formats, B and b. A binary Float64
+	// is an IEEE 754 double, a text one, format R, is ASCII.
+	Binary bool
 }
 
 // FieldType holds the metadata describing fields and subfields.
@@ -127,20 +250,188 @@ type FieldType struct {
 	Name              []byte
 	ArrayDescriptor   []byte
 	FormatControls    []byte
-	SubFields         []SubFieldType
+	// Extra holds any parts of the description after the FormatControls.
+	// ISO 8211 does not define them, they are kept so Write reproduces
+	// them and are otherwise ignored.
+	Extra [][]byte
+	// SubFields overrides the FormatControls when set. Format fills it
+	// for a FieldType built by hand, but keeps the types of one read
+	// from a lead record in a cache shared by its copies, so use Format
+	// rather than SubFields to get them.
+	SubFields []SubFieldType
+	// ByteOrder of the binary subfields. S-57 is LSB first, so nil
+	// means binary.LittleEndian.
+	ByteOrder binary.ByteOrder
+	// LexicalLevel of the text subfields. Level 2 text is UCS-2 in
+	// ByteOrder with two byte terminators, levels 0 and 1 are 8 bit.
+	// Read sets it from the EscapeSeq.
+	LexicalLevel int
+	// Terminators of the description and the fields, the standard ones
+	// when zero.
+	Terminators Terminators
+	// format caches Format for every copy of a FieldType read from a
+	// lead record, so the fields sharing it can be decoded concurrently.
+	format *formatCache
+	// controlLength is the FieldControlLength of the lead record, the
+	// size of the field controls before the Name. 0 means 9.
+	controlLength int
+}
+
+type formatCache struct {
+	once  sync.Once
+	types []SubFieldType
+}
+
+// DataStructure is the ISO 8211 data structure code of a FieldType.
+type DataStructure byte
+
+// The data structure codes. In S-57 the 0001 record identifier field is
+// elementary, the record identifier fields such as DSID and FRID are
+// linear (vector) and the repeating fields such as ATTF and SG2D are
+// arrays.
+const (
+	Elementary   DataStructure = '0' // A single data item, e.g. field 0000.
+	Linear       DataStructure = '1' // A vector of subfields.
+	Array        DataStructure = '2' // A multi-dimensional array.
+	Concatenated DataStructure = '3' // A concatenation of the other structures.
+)
+
+func (s DataStructure) String() string {
+	switch s {
+	case Elementary:
+		return "elementary"
+	case Linear:
+		return "linear"
+	case Array:
+		return "array"
+	case Concatenated:
+		return "concatenated"
+	}
+	return "unknown data structure " + strconv.Quote(string(s))
+}
+
+// DataType is the ISO 8211 data type code of a FieldType.
+type DataType byte
+
+// The data type codes.
+const (
+	CharacterString DataType = '0'
+	ImplicitPoint   DataType = '1' // Integer.
+	ExplicitPoint   DataType = '2' // Real, fixed point.
+	ScaledPoint     DataType = '3' // Real, explicit scaled.
+	CharacterBits   DataType = '4' // Character mode bit string.
+	BitString       DataType = '5' // Bit string, including binary forms.
+	MixedDataTypes  DataType = '6'
+)
+
+func (t DataType) String() string {
+	switch t {
+	case CharacterString:
+		return "character string"
+	case ImplicitPoint:
+		return "implicit point"
+	case ExplicitPoint:
+		return "explicit point"
+	case ScaledPoint:
+		return "explicit point scaled"
+	case CharacterBits:
+		return "character mode bit string"
+	case BitString:
+		return "bit string"
+	case MixedDataTypes:
+		return "mixed data types"
+	}
+	return "unknown data type " + strconv.Quote(string(t))
+}
+
+// CodeExtension is the in line code extension indicator of a leader.
+type CodeExtension byte
+
+// The in line code extension indicators. S-57 lead records are
+// InLineExtension, their data records NoCodeExtension.
+const (
+	NoCodeExtension CodeExtension = ' '
+	// InLineExtension means text subfields may switch character sets
+	// with escape sequences. Read keeps the escape sequences in the text,
+	// only the LexicalLevel of the field, see FieldType.EscapeSeq, is
+	// decoded.
+	InLineExtension CodeExtension = 'E'
+)
+
+func (c CodeExtension) String() string {
+	switch c {
+	case NoCodeExtension:
+		return "none"
+	case InLineExtension:
+		return "in line"
+	}
+	return "unknown code extension " + strconv.Quote(string(c))
+}
+
+// CodeExtension returns the typed in line code extension indicator of
+// the leader.
+func (header *Header) CodeExtension() CodeExtension {
+	return CodeExtension(header.InLineCode)
+}
+
+func (dir FieldType) byteOrder() binary.ByteOrder {
+	if dir.ByteOrder == nil {
+		return binary.LittleEndian
+	}
+	return dir.ByteOrder
+}
+
+// Structure returns the typed data structure code of the field.
+func (dir FieldType) Structure() DataStructure {
+	return DataStructure(dir.DataStructure)
+}
+
+// Type returns the typed data type code of the field.
+func (dir FieldType) Type() DataType {
+	return DataType(dir.DataType)
+}
+
+// Repeats reports whether the subfields repeat to fill the field, as
+// they do for Array and Concatenated fields and when the array descriptor
+// starts with '*'. Elementary and Linear fields have a single row of
+// subfields; fields with an unknown structure are read as repeating.
+func (dir FieldType) Repeats() bool {
+	switch dir.Structure() {
+	case Elementary, Linear:
+		return bytes.HasPrefix(dir.ArrayDescriptor, []byte{'*'})
+	}
+	return true
 }
 
 // Read loads a binary format RawHeader and its DirEntries into
-// the Header model.
+// the Header model. It returns io.EOF, unwrapped, when file has no more
+// records; other errors wrap the io error with the record Offset. A
+// leader whose identifier is not L, D or R, or whose entry sizes are not
+// digits, is not an ISO 8211 record, ErrNotISO8211.
 func (header *Header) Read(file io.Reader) error {
 	var err error
 	var ddr RawHeader
 	ddrSize := uint64(binary.Size(ddr))
 	// Read the header
 	err = binary.Read(file, binary.LittleEndian, &ddr)
-	if err != nil {
+	if err == io.EOF {
 		return err
 	}
+	if err != nil {
+		return fmt.Errorf("record at offset %d: leader: %w", header.Offset, err)
+	}
+	switch ddr.LeaderID {
+	case 'L', 'D', 'R':
+	default:
+		return fmt.Errorf("record at offset %d: leader identifier %q is not L, D or R, %w",
+			header.Offset, ddr.LeaderID, ErrNotISO8211)
+	}
+	for _, size := range []byte{ddr.SizeOfFieldLength, ddr.SizeOfFieldPosition, ddr.SizeOfFieldTag} {
+		if size < '1' || size > '9' {
+			return fmt.Errorf("record at offset %d: directory entry size %q is not a digit, %w",
+				header.Offset, size, ErrNotISO8211)
+		}
+	}
 	header.RecordLength, _ = strconv.ParseUint(string(ddr.RecordLength[:]), 10, 64)
 	header.InterchangeLevel = ddr.InterchangeLevel
 	header.LeaderID = ddr.LeaderID
@@ -153,32 +444,293 @@ func (header *Header) Read(file io.Reader) error {
 	header.LengthSize = int8(ddr.SizeOfFieldLength - '0')
 	header.PositionSize = int8(ddr.SizeOfFieldPosition - '0')
 	header.TagSize = int8(ddr.SizeOfFieldTag - '0')
+	if header.BaseAddress < ddrSize+1 {
+		// The directory would have a negative size.
+		return fmt.Errorf("record at offset %d: base address %q is less than %d, the size of the leader and field terminator",
+			header.Offset, ddr.BaseAddress[:], ddrSize+1)
+	}
 	// Read the directory
 	entries := (header.BaseAddress - 1 - ddrSize) / uint64(header.LengthSize+header.PositionSize+header.TagSize)
-	header.Entries = make([]DirEntry, entries)
+	if len(header.Entries) == 0 && uint64(cap(header.Entries)) >= entries {
+		// Reuse the directory cleared by DataRecord.Reset.
+		header.Entries = header.Entries[:entries]
+	} else {
+		header.Entries = make([]DirEntry, entries)
+	}
 	dir := make([]byte, header.BaseAddress-ddrSize)
-	file.Read(dir)
+	_, err = io.ReadFull(file, dir)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return fmt.Errorf("record at offset %d: directory: %w", header.Offset, err)
+	}
 	buf := bytes.NewBuffer(dir)
 	for idx := uint64(0); idx < entries; idx++ {
-		header.Entries[idx].Tag = buf.Next(int(header.TagSize))
-		header.Entries[idx].Length, _ = strconv.Atoi(string(buf.Next(int(header.LengthSize))[:]))
-		header.Entries[idx].Position, _ = strconv.Atoi(string(buf.Next(int(header.PositionSize))[:]))
+		e := &header.Entries[idx]
+		e.Tag = buf.Next(int(header.TagSize))
+		length := buf.Next(int(header.LengthSize))
+		position := buf.Next(int(header.PositionSize))
+		if e.Length, err = parseDigits(length); err != nil {
+			return fmt.Errorf("record at offset %d: directory entry %d, field %s: length %q is not a number",
+				header.Offset, idx, e.Tag, length)
+		}
+		if e.Position, err = parseDigits(position); err != nil {
+			return fmt.Errorf("record at offset %d: directory entry %d, field %s: position %q is not a number",
+				header.Offset, idx, e.Tag, position)
+		}
+	}
+	if header.RecordLength == 0 {
+		// Some producers leave the record length blank, the directory
+		// gives it.
+		header.RecordLength = header.length()
 	}
 	return err
 }
 
-// Read loads the LeadRecord Header and the FieldTypes
+// parseDigits parses a directory entry length or position, which must be
+// all decimal digits.
+func parseDigits(b []byte) (int, error) {
+	n, err := strconv.ParseUint(string(b), 10, 31)
+	return int(n), err
+}
+
+// checkLeadID returns ErrNotLeadRecord if the leader identifier is not L.
+func (header *Header) checkLeadID() error {
+	if header.LeaderID != 'L' {
+		return fmt.Errorf("record at offset %d: leader identifier %q: %w", header.Offset, header.LeaderID, ErrNotLeadRecord)
+	}
+	return nil
+}
+
+// checkDataID returns ErrNotDataRecord if the leader identifier is not D.
+func (header *Header) checkDataID() error {
+	if header.LeaderID != 'D' {
+		return fmt.Errorf("record at offset %d: leader identifier %q: %w", header.Offset, header.LeaderID, ErrNotDataRecord)
+	}
+	return nil
+}
+
+// checkLeader returns an error if the interchange level, in line code
+// extension indicator, version or application indicator of a lead record
+// leader is not one ISO 8211 permits. The application indicator is
+// defined by the application, blank in S-57, so any printable character
+// is accepted.
+func (header *Header) checkLeader() error {
+	if header.InterchangeLevel < '1' || header.InterchangeLevel > '3' {
+		return fmt.Errorf("record at offset %d: interchange level %q is not 1, 2 or 3",
+			header.Offset, header.InterchangeLevel)
+	}
+	if c := header.CodeExtension(); c != NoCodeExtension && c != InLineExtension {
+		return fmt.Errorf("record at offset %d: in line code extension indicator %q is not blank or E",
+			header.Offset, header.InLineCode)
+	}
+	if header.Version != ' ' && header.Version != '1' {
+		return fmt.Errorf("record at offset %d: version %q is not blank or 1", header.Offset, header.Version)
+	}
+	if header.ApplicationIndicator < ' ' || header.ApplicationIndicator > '~' {
+		return fmt.Errorf("record at offset %d: application indicator %q is not a printable character",
+			header.Offset, header.ApplicationIndicator)
+	}
+	return nil
+}
+
+// checkDataLeader returns an error if the in line code extension
+// indicator or application indicator of a data record leader, which
+// ISO 8211 leaves blank, is not.
+func (header *Header) checkDataLeader() error {
+	if header.InLineCode != ' ' {
+		return fmt.Errorf("record at offset %d: in line code extension indicator %q is not blank",
+			header.Offset, header.InLineCode)
+	}
+	if header.ApplicationIndicator != ' ' {
+		return fmt.Errorf("record at offset %d: application indicator %q is not blank",
+			header.Offset, header.ApplicationIndicator)
+	}
+	return nil
+}
+
+// Validate checks that the directory entries tile the field area: the
+// first field is at position 0, each field starts where the previous one
+// ends, without gaps or overlaps, and the fields end at the RecordLength.
+// Strict records are validated before their fields are read.
+func (header *Header) Validate() error {
+	next := 0
+	for _, d := range header.Entries {
+		switch {
+		case d.Length < 1:
+			return fmt.Errorf("record at offset %d: field %s has length %d", header.Offset, d.Tag, d.Length)
+		case d.Position < next:
+			return fmt.Errorf("record at offset %d: field %s at position %d overlaps the field before it, which ends at %d",
+				header.Offset, d.Tag, d.Position, next)
+		case d.Position > next:
+			return fmt.Errorf("record at offset %d: field %s at position %d leaves a gap after position %d",
+				header.Offset, d.Tag, d.Position, next)
+		}
+		next = d.Position + d.Length
+	}
+	return header.checkLength()
+}
+
+// checkLength returns an error if the RecordLength is not the number of
+// bytes of the leader, directory and fields.
+func (header *Header) checkLength() error {
+	if n := header.length(); n != header.RecordLength {
+		return fmt.Errorf("record at offset %d: record length is %d but the record has %d bytes",
+			header.Offset, header.RecordLength, n)
+	}
+	return nil
+}
+
+// length returns the number of bytes of the leader, directory and fields.
+func (header *Header) length() uint64 {
+	n := header.BaseAddress
+	for _, d := range header.Entries {
+		n += uint64(d.Length)
+	}
+	return n
+}
+
+// Read loads the LeadRecord Header and the FieldTypes. A record that is
+// not a lead record is ErrNotLeadRecord.
 func (lead *LeadRecord) Read(file io.Reader) error {
+	if err := lead.Header.Read(file); err != nil {
+		return err
+	}
+	return lead.readBody(file)
+}
+
+// readBody is Read after the Header: it checks the Header and reads the
+// FieldTypes.
+func (lead *LeadRecord) readBody(file io.Reader) error {
 	var err error
-	err = lead.Header.Read(file)
-	if err != nil {
+	if err = lead.Header.checkLeadID(); err != nil {
 		return err
 	}
-	if lead.Header.LeaderID != 'L' {
-		return errors.New("record is not a Lead record")
+	if err = lead.Limits.check(&lead.Header); err != nil {
+		return err
 	}
-	err = lead.ReadFields(file)
-	return err
+	if lead.Strict {
+		if err = lead.Header.checkLeader(); err != nil {
+			return err
+		}
+		if err = lead.Header.Validate(); err != nil {
+			return err
+		}
+	}
+	return lead.ReadFields(file)
+}
+
+// Validate checks that the format controls of every FieldType parse and
+// fit its array descriptor: each format control is known and the tags
+// take the formats, after expanding repeat counts, a whole number of
+// times. Subfield labels embedded in the format controls, e.g.
+// "(A&NAME)", are reported as unsupported; the tags must be in the array
+// descriptor. Field types without format controls, such as the 0000 file
+// control field, are skipped. Every problem is listed in the error.
+func (lead *LeadRecord) Validate() error {
+	var problems []string
+	for _, tag := range lead.Tags() {
+		ft := lead.FieldTypes[tag]
+		for _, p := range ft.validate() {
+			problems = append(problems, fmt.Sprintf("field %s: %s", tag, p))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("lead record: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validate returns the problems LeadRecord.Validate finds with the format
+// controls of the FieldType.
+func (dir *FieldType) validate() []string {
+	if len(dir.FormatControls) == 0 || string(dir.FormatControls) == "()" {
+		return nil
+	}
+	if bytes.IndexByte(dir.FormatControls, '&') >= 0 {
+		return []string{fmt.Sprintf("subfield labels in the format controls %q are not supported, use the array descriptor",
+			dir.FormatControls)}
+	}
+	var problems []string
+	formats := parseFormats(dir.FormatControls)
+	for i, st := range formats {
+		if st.Kind == reflect.Invalid {
+			problems = append(problems, fmt.Sprintf("unknown format control for subfield %d in %q", i, dir.FormatControls))
+		}
+	}
+	tags := len(bytes.Split(bytes.TrimPrefix(dir.ArrayDescriptor, []byte{'*'}), []byte{'!'}))
+	if len(formats) == 0 || tags%len(formats) != 0 {
+		problems = append(problems, fmt.Sprintf("%d tags in %q do not fit the %d formats in %q",
+			tags, dir.ArrayDescriptor, len(formats), dir.FormatControls))
+	}
+	return problems
+}
+
+// Tags returns the tags of the FieldTypes, sorted.
+func (lead *LeadRecord) Tags() []string {
+	tags := make([]string, 0, len(lead.FieldTypes))
+	for tag := range lead.FieldTypes {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// FieldSchema returns the subfield types of the FieldType with the tag:
+// the tag, Go kind and width of each subfield, from its array descriptor
+// and format controls, without decoding any data. The slice is a copy.
+// It is an error if the lead record has no FieldType with the tag or a
+// format control is not supported. A field without format controls, such
+// as the 0000 file control field, has no subfield types.
+func (lead *LeadRecord) FieldSchema(tag string) ([]SubFieldType, error) {
+	ft, ok := lead.FieldTypes[tag]
+	if !ok {
+		return nil, fmt.Errorf("field %s: no field type in the lead record", tag)
+	}
+	types := append([]SubFieldType(nil), ft.Format()...)
+	for _, st := range types {
+		if st.Kind == reflect.Invalid {
+			return types, fmt.Errorf("field %s: unsupported format control for subfield %s in %q", tag, st.Tag, ft.FormatControls)
+		}
+	}
+	return types, nil
+}
+
+// SetByteOrder sets the ByteOrder of every FieldType, for files whose
+// binary subfields are not LSB first.
+func (lead *LeadRecord) SetByteOrder(order binary.ByteOrder) {
+	for tag, ft := range lead.FieldTypes {
+		ft.ByteOrder = order
+		lead.FieldTypes[tag] = ft
+	}
+}
+
+// SetLexicalLevel sets the LexicalLevel of the FieldTypes with the given
+// tags. S-57 gives the levels of ATTF/ATTV and NATF in the AALL and NALL
+// subfields of the DSSI field; File applies them automatically.
+func (lead *LeadRecord) SetLexicalLevel(level int, tags ...string) {
+	for _, tag := range tags {
+		if ft, ok := lead.FieldTypes[tag]; ok {
+			ft.LexicalLevel = level
+			lead.FieldTypes[tag] = ft
+		}
+	}
+}
+
+// The escape sequences of the S-57 lexical levels: level 0 is ASCII,
+// level 1 ISO 8859-1 and level 2 UCS-2.
+var escapeSequences = [...]string{"   ", "-A ", "%/A"}
+
+// lexicalLevel returns the lexical level of an escape sequence, 0 for
+// sequences that are not known.
+func lexicalLevel(esc []byte) int {
+	for level, seq := range escapeSequences {
+		if string(esc) == seq {
+			return level
+		}
+	}
+	return 0
 }
 
 func (lead *LeadRecord) ReadFields(file io.Reader) error {
@@ -186,66 +738,442 @@ func (lead *LeadRecord) ReadFields(file io.Reader) error {
 	lead.FieldTypes = make(map[string]FieldType, len(lead.Header.Entries))
 	for _, d := range lead.Header.Entries {
 		field := FieldType{Tag: string(d.Tag), Length: d.Length, Position: d.Position}
-		field.Read(file)
+		field.controlLength = int(lead.Header.FieldControlLength)
+		field.Terminators = lead.Terminators
+		err = field.Read(file)
+		if err != nil {
+			offset := lead.Header.Offset + int64(lead.Header.BaseAddress) + int64(d.Position)
+			return fmt.Errorf("lead record at offset %d: %w", offset, err)
+		}
 		lead.FieldTypes[field.Tag] = field
 	}
+	lead.TagPairs = lead.readTagPairs()
 	return err
 }
 
+// readTagPairs parses the tag pairs of the field control field, whose
+// array descriptor is the parent and child tags of each pair in turn.
+func (lead *LeadRecord) readTagPairs() []TagPair {
+	control, ok := lead.FieldTypes["0000"]
+	size := int(lead.Header.TagSize)
+	if !ok || size < 1 {
+		return nil
+	}
+	tags := control.ArrayDescriptor
+	var pairs []TagPair
+	for i := 0; i+2*size <= len(tags); i += 2 * size {
+		pairs = append(pairs, TagPair{string(tags[i : i+size]), string(tags[i+size : i+2*size])})
+	}
+	return pairs
+}
+
 func (field *Field) Read(file io.Reader) error {
+	return field.read(file, nil, false, true)
+}
+
+// read reads the field data, decoding it when decode is set and keeping
+// it in Raw when keepRaw is set or the Field is unresolved. Data that is
+// not kept is read into scratch, if given, which is grown as needed.
+// Empty SubFields with capacity are reused, and are nil when the data is
+// not decoded.
+func (field *Field) read(file io.Reader, scratch *[]byte, keepRaw, decode bool) error {
 	var err error
-	data := make([]byte, field.Length)
-	file.Read(data)
-	if field.FieldType.Tag != "" {
-		field.SubFields = field.FieldType.Decode(data[:field.Length-1])
+	keep := keepRaw || !field.Resolved()
+	var data []byte
+	switch {
+	case keep || scratch == nil:
+		data = make([]byte, field.Length)
+	case cap(*scratch) >= field.Length:
+		data = (*scratch)[:field.Length]
+	default:
+		data = make([]byte, field.Length)
+		*scratch = data
+	}
+	_, err = io.ReadFull(file, data)
+	if err != nil {
+		return err
+	}
+	if field.Resolved() && decode {
+		var values []interface{}
+		if len(field.SubFields) == 0 {
+			values = field.SubFields
+		}
+		field.SubFields, _ = field.FieldType.decodeAppend(values, field.FieldType.trimTerminator(data))
+		if keepRaw {
+			field.Raw = data
+		}
+		return err
+	}
+	field.SubFields = nil
+	if keep && field.Length > 0 {
+		field.Raw = data
 	}
 	return err
 }
 
+// trimTerminator returns the field data without its field terminator.
+func (dir FieldType) trimTerminator(data []byte) []byte {
+	end := len(data) - 1
+	if end < 0 {
+		return data
+	}
+	if dir.LexicalLevel == 2 && end > 0 && dir.byteOrder().Uint16(data[end-1:]) == uint16(dir.Terminators.field()) {
+		// The UCS-2 field terminator is two bytes.
+		end--
+	}
+	return data[:end]
+}
+
+// Decode decodes the SubFields of the Fields of a record read Lazy from
+// their Raw data, which is then dropped unless the record is KeepRaw. It
+// returns the first decoding error. The records of a File read Lazy may
+// be decoded concurrently: a record's Fields hold copies of the lead
+// record FieldTypes, which share only the Format cache, and it is safe
+// for concurrent use.
+func (data *DataRecord) Decode() error {
+	var first error
+	for i := range data.Fields {
+		f := &data.Fields[i]
+		if !f.Resolved() || f.SubFields != nil || f.Raw == nil {
+			continue
+		}
+		var err error
+		f.SubFields, err = f.FieldType.decode(f.FieldType.trimTerminator(f.Raw))
+		if err != nil && first == nil {
+			first = err
+		}
+		if !data.KeepRaw {
+			f.Raw = nil
+		}
+	}
+	return first
+}
+
+// EachSubField calls fn with the tag and value of each subfield of each
+// resolved Field in turn. The SubFields of a Field read Lazy are decoded
+// from its Raw data as fn is called, without being stored, so a large
+// record can be scanned without holding all of its values. It stops at
+// the first error of fn or of decoding and returns it.
+func (data *DataRecord) EachSubField(fn func(fieldTag, subTag string, value interface{}) error) error {
+	for i := range data.Fields {
+		f := &data.Fields[i]
+		if !f.Resolved() {
+			continue
+		}
+		if f.SubFields == nil && f.Raw != nil {
+			err := f.FieldType.walk(f.FieldType.trimTerminator(f.Raw), func(ftype SubFieldType, v interface{}, err error) error {
+				if err != nil {
+					return err
+				}
+				return fn(f.Tag, string(ftype.Tag), v)
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		types := f.FieldType.Format()
+		for j, v := range f.SubFields {
+			var tag string
+			if len(types) > 0 {
+				tag = string(types[j%len(types)].Tag)
+			}
+			if err := fn(f.Tag, tag, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Resolved reports whether the Field has a FieldType from the lead record.
+// The SubFields of an unresolved Field are not decoded.
+func (field *Field) Resolved() bool {
+	return field.FieldType.Tag != ""
+}
+
+// SubField returns the first decoded value of the subfield tagged name.
+func (field *Field) SubField(name string) (interface{}, bool) {
+	types := field.FieldType.Format()
+	if len(types) == 0 {
+		return nil, false
+	}
+	for i, v := range field.SubFields {
+		if string(types[i%len(types)].Tag) == name {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// SubFieldValues returns every decoded value of the subfield tagged name,
+// for fields that repeat their subfields.
+func (field *Field) SubFieldValues(name string) []interface{} {
+	types := field.FieldType.Format()
+	if len(types) == 0 {
+		return nil
+	}
+	var values []interface{}
+	for i, v := range field.SubFields {
+		if string(types[i%len(types)].Tag) == name {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// String renders the Field as its tag followed by each subfield tag and
+// value, e.g. "ATTF: ATTL=178 ATVL=\"5\"". Each repeat of the format
+// is separated by a semicolon. Terminators in text values are shown as
+// the printable graphics of the FieldType, see printable.
+func (field Field) String() string {
+	var buf bytes.Buffer
+	buf.WriteString(field.Tag)
+	buf.WriteByte(':')
+	types := field.FieldType.Format()
+	for i, v := range field.SubFields {
+		if len(types) > 0 && i > 0 && i%len(types) == 0 {
+			buf.WriteByte(';')
+		}
+		buf.WriteByte(' ')
+		if len(types) > 0 && len(types[i%len(types)].Tag) > 0 {
+			buf.Write(types[i%len(types)].Tag)
+			buf.WriteByte('=')
+		}
+		switch v := v.(type) {
+		case string:
+			buf.WriteString(strconv.Quote(field.FieldType.printable(v)))
+		case []byte:
+			fmt.Fprintf(&buf, "%x", v)
+		default:
+			fmt.Fprint(&buf, v)
+		}
+	}
+	return buf.String()
+}
+
+// printable replaces the field and unit terminators in s with the
+// PrintableFt and PrintableUt graphics, e.g. ';' and '&' in S-57. A
+// blank graphic, the default, leaves its terminator to be escaped.
+func (dir FieldType) printable(s string) string {
+	var pairs []string
+	if g := dir.PrintableFt; g > ' ' && g <= '~' {
+		pairs = append(pairs, string(dir.Terminators.field()), string(g))
+	}
+	if g := dir.PrintableUt; g > ' ' && g <= '~' {
+		pairs = append(pairs, string(dir.Terminators.unit()), string(g))
+	}
+	if len(pairs) == 0 {
+		return s
+	}
+	return strings.NewReplacer(pairs...).Replace(s)
+}
+
+// String renders the DataRecord as one line per Field.
+func (data DataRecord) String() string {
+	lines := make([]string, len(data.Fields))
+	for i, f := range data.Fields {
+		lines[i] = f.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Field returns the first Field with the tag.
+func (data *DataRecord) Field(tag string) (*Field, bool) {
+	for i := range data.Fields {
+		if data.Fields[i].Tag == tag {
+			return &data.Fields[i], true
+		}
+	}
+	return nil, false
+}
+
+// FieldsByTag returns every Field with the tag, for records that repeat
+// a field.
+func (data *DataRecord) FieldsByTag(tag string) []*Field {
+	var fields []*Field
+	for i := range data.Fields {
+		if data.Fields[i].Tag == tag {
+			fields = append(fields, &data.Fields[i])
+		}
+	}
+	return fields
+}
+
+// RawFields returns the data of the Fields that could not be decoded
+// because they have no FieldType, e.g. every field of a DataRecord read
+// without a Lead. It lets the structure of a record be inspected without
+// its lead record.
+func (data *DataRecord) RawFields() []RawField {
+	var fields []RawField
+	for i := range data.Fields {
+		f := &data.Fields[i]
+		if f.Resolved() {
+			continue
+		}
+		var raw []byte
+		if len(f.Raw) > 0 {
+			raw = f.Raw[:len(f.Raw)-1]
+		}
+		t := f.FieldType.Terminators
+		if data.Lead != nil {
+			t = data.Lead.Terminators
+		}
+		units := bytes.Split(raw, []byte{t.unit()})
+		if len(units) > 1 && len(units[len(units)-1]) == 0 {
+			units = units[:len(units)-1]
+		}
+		fields = append(fields, RawField{f.Tag, raw, units})
+	}
+	return fields
+}
+
+// Read loads the next DataRecord Header and its Fields. It returns io.EOF
+// when file ends cleanly before the record and an error wrapping
+// io.ErrUnexpectedEOF, with the offset and tag, when it ends part way
+// through one. A record that is not a data record is ErrNotDataRecord.
 func (data *DataRecord) Read(file io.Reader) error {
 	var err error
+	if seeker, ok := file.(io.Seeker); ok {
+		if offset, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			data.Header.Offset = offset
+		}
+	}
 	err = data.Header.Read(file)
 	if err != nil {
 		return err
 	}
-	if data.Header.LeaderID != 'D' {
-		return errors.New("record is not a Data record")
+	return data.readBody(file)
+}
+
+// readBody is Read after the Header: it checks the Header and reads the
+// Fields.
+func (data *DataRecord) readBody(file io.Reader) error {
+	var err error
+	if err = data.Header.checkDataID(); err != nil {
+		return err
 	}
-	err = data.ReadFields(file)
-	return err
+	if err = data.Limits.check(&data.Header); err != nil {
+		return err
+	}
+	if data.Strict {
+		if err = data.Header.checkDataLeader(); err != nil {
+			return err
+		}
+		if err = data.Header.Validate(); err != nil {
+			return err
+		}
+	}
+	return data.ReadFields(file)
+}
+
+// Reset clears the DataRecord for the next Read, keeping its Lead and
+// settings. The next Read reuses the memory of the Fields, their
+// SubFields and the directory, so the data of the previous record, and
+// any slices of it the caller kept, are invalid after Reset.
+func (data *DataRecord) Reset() {
+	fields := data.Fields[:cap(data.Fields)]
+	for i := range fields {
+		fields[i] = Field{SubFields: fields[i].SubFields[:0]}
+	}
+	data.Fields = fields[:0]
+	data.Header = Header{Entries: data.Header.Entries[:0]}
 }
 
 func (data *DataRecord) ReadFields(file io.Reader) error {
 	var err error
-	data.Fields = make([]Field, len(data.Header.Entries))
+	n := len(data.Header.Entries)
+	if len(data.Fields) == 0 && cap(data.Fields) >= n {
+		// Reuse the Fields cleared by Reset.
+		data.Fields = data.Fields[:n]
+	} else {
+		data.Fields = make([]Field, n)
+	}
 	for i, d := range data.Header.Entries {
-		field := Field{Tag: string(d.Tag), Length: d.Length, Position: d.Position}
+		field := Field{Length: d.Length, Position: d.Position, SubFields: data.Fields[i].SubFields}
+		field.Offset = data.Header.Offset + int64(data.Header.BaseAddress) + int64(d.Position)
 		if data.Lead != nil {
-			field.FieldType = data.Lead.FieldTypes[field.Tag]
+			field.FieldType = data.Lead.FieldTypes[string(d.Tag)]
+		}
+		// Share the tag of the FieldType rather than allocate one.
+		field.Tag = field.FieldType.Tag
+		if field.Tag == "" {
+			field.Tag = string(d.Tag)
+		}
+		decode := !data.Lazy && (data.DecodeTags == nil || data.DecodeTags[field.Tag])
+		err = field.read(file, &data.buf, data.KeepRaw || data.Lazy, decode)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil && data.Strict && !field.Resolved() {
+			err = errors.New("no field type in the lead record")
+		}
+		if err != nil {
+			return fmt.Errorf("field %s at offset %d: %w", field.Tag, field.Offset, err)
 		}
-		err = field.Read(file)
 		data.Fields[i] = field
 	}
 	return err
 }
 
+// Read loads a FieldType description from the lead record. A description
+// without an array descriptor is an error, the FieldType is left with
+// just its Name.
 func (dir *FieldType) Read(file io.Reader) error {
+	controls := dir.controlLength
+	if controls == 0 {
+		controls = binary.Size(RawFieldHeader{})
+	}
+	if dir.Length < controls+1 {
+		return fmt.Errorf("field %s: length %d is too short for a field description", dir.Tag, dir.Length)
+	}
+	dir.format = new(formatCache)
+	// Shorter field controls, such as the 6 of ISO 8211:1985, leave the
+	// rest of the RawFieldHeader blank; longer ones are skipped.
+	raw := bytes.Repeat([]byte{' '}, binary.Size(RawFieldHeader{}))
+	b := make([]byte, controls)
+	_, err := io.ReadFull(file, b)
+	copy(raw, b)
 	var field RawFieldHeader
-	err := binary.Read(file, binary.LittleEndian, &field)
+	binary.Read(bytes.NewReader(raw), binary.LittleEndian, &field)
 	dir.DataStructure = field.DataStructure
 	dir.DataType = field.DataType
 	dir.AuxiliaryControls = field.AuxiliaryControls[:]
 	dir.PrintableFt = field.PrintableFt
 	dir.PrintableUt = field.PrintableUt
 	dir.EscapeSeq = field.EscapeSeq[:]
-	fdata := make([]byte, dir.Length-9)
-	file.Read(fdata)
-	desc := bytes.Split(fdata[:dir.Length-10], []byte{'\x1f'})
+	dir.LexicalLevel = lexicalLevel(dir.EscapeSeq)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return fmt.Errorf("field %s: %w", dir.Tag, err)
+	}
+	fdata := make([]byte, dir.Length-controls)
+	_, err = io.ReadFull(file, fdata)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return fmt.Errorf("field %s: %w", dir.Tag, err)
+	}
+	// The description ends at its field terminator, normally the last
+	// byte.
+	if end := bytes.IndexByte(fdata, dir.Terminators.field()); end >= 0 {
+		fdata = fdata[:end]
+	}
+	desc := bytes.Split(fdata, []byte{dir.Terminators.unit()})
 	dir.Name = desc[0]
+	if len(desc) < 2 {
+		return fmt.Errorf("field %s: description has no array descriptor", dir.Tag)
+	}
 	dir.ArrayDescriptor = desc[1]
 	if len(desc) > 2 {
 		dir.FormatControls = desc[2]
 	}
+	if len(desc) > 3 {
+		dir.Extra = desc[3:]
+	}
 	return err
 }
 
@@ -266,120 +1194,514 @@ bytes.
 Decriptor *YCOO!XCOO, Format (2b24) is two binary encoded integers. Both are
 int32s, the '2' after the 'b' indicates signed. The * in the descriptor
 indicates that pair is repeated to fill the data field.
+A repeat count may also apply to a parenthesized group, Format (2(b11,b12))
+is the same as (b11,b12,b11,b12).
+Empty format controls, (), make each tag a variable width text subfield.
+
+The result is cached. The copies of a FieldType read from a lead record
+share their cache, so records using them may be decoded concurrently.
 */
 func (dir *FieldType) Format() []SubFieldType {
 	if dir.SubFields != nil {
 		return dir.SubFields
 	}
-	var re = regexp.MustCompile(`(\d*)(\w+)\(*(\d*)\)*`)
+	if dir.format != nil {
+		// Shared with the copies of a FieldType read from a lead record,
+		// so it is parsed once and dir is left unchanged.
+		dir.format.once.Do(func() { dir.format.types = dir.parseFormat() })
+		return dir.format.types
+	}
+	dir.SubFields = dir.parseFormat()
+	return dir.SubFields
+}
+
+func (dir *FieldType) parseFormat() []SubFieldType {
+	var formats []SubFieldType
+	switch {
+	case string(dir.FormatControls) == "()":
+		// No format, every subfield is variable width ASCII.
+		formats = []SubFieldType{{reflect.String, 0, nil, false}}
+	case len(dir.FormatControls) > 2:
+		formats = parseFormats(dir.FormatControls)
+	}
+	if len(formats) == 0 {
+		return nil
+	}
+	Tags := bytes.Split(bytes.TrimPrefix(dir.ArrayDescriptor, []byte{'*'}), []byte{'!'})
+	types := make([]SubFieldType, len(Tags))
+	for i, tag := range Tags {
+		// The formats are reused in turn when there are more tags.
+		types[i] = formats[i%len(formats)]
+		types[i].Tag = tag
+	}
+	return types
+}
+
+// maxFormatTypes bounds the subfields format controls expand to, so a
+// repeat count such as "(99999999b11)" cannot exhaust memory.
+const maxFormatTypes = 1 << 16
+
+// parseFormats expands format controls such as "(b11,2(A,b12))" into an
+// untagged SubFieldType for each subfield. A repeat count may precede a
+// single control or a parenthesized group of them. Unknown controls have
+// an invalid Kind, as are format controls that expand to more than
+// maxFormatTypes subfields.
+func parseFormats(controls []byte) []SubFieldType {
+	types, ok := expandFormats(controls)
+	if !ok {
+		return []SubFieldType{{}}
+	}
+	return types
+}
 
-	if len(dir.FormatControls) > 2 {
-		Tags := bytes.Split(dir.ArrayDescriptor, []byte{'!'})
-		Tagidx := 0
-		types := make([]SubFieldType, len(Tags))
-		for _, a := range re.FindAllSubmatch(dir.FormatControls, -1) {
-			i := 1
-			if len(a[1]) > 0 {
-				i, _ = strconv.Atoi(string(a[1]))
+// expandFormats is parseFormats, but reports format controls that expand
+// to more than maxFormatTypes subfields as not ok.
+func expandFormats(controls []byte) ([]SubFieldType, bool) {
+	if len(controls) > 1 && controls[0] == '(' && controls[len(controls)-1] == ')' {
+		controls = controls[1 : len(controls)-1]
+	}
+	var types []SubFieldType
+	for _, item := range splitFormats(controls) {
+		digits := 0
+		for digits < len(item) && item[digits] >= '0' && item[digits] <= '9' {
+			digits++
+		}
+		i := 1
+		var err error
+		if digits > 0 {
+			if i, err = strconv.Atoi(string(item[:digits])); err != nil {
+				return nil, false
 			}
-			var size int
-			if len(a[3]) > 0 {
-				size, _ = strconv.Atoi(string(a[3]))
+		}
+		item = item[digits:]
+		var group []SubFieldType
+		if len(item) > 0 && item[0] == '(' {
+			var ok bool
+			if group, ok = expandFormats(item); !ok {
+				return nil, false
 			}
-			for ; i > 0; i-- {
-				switch a[2][0] {
-				case 'A':
-					types[Tagidx] = SubFieldType{reflect.String, size, Tags[Tagidx]}
-				case 'I':
-					types[Tagidx] = SubFieldType{reflect.String, size, Tags[Tagidx]}
-				case 'R':
-					types[Tagidx] = SubFieldType{reflect.String, size, Tags[Tagidx]}
-				case 'B':
-					types[Tagidx] = SubFieldType{reflect.Array, size / 8, Tags[Tagidx]}
-				case 'b':
-					switch string(a[2][1:]) {
-					case "11":
-						types[Tagidx] = SubFieldType{reflect.Uint8, 1, Tags[Tagidx]}
-					case "12":
-						types[Tagidx] = SubFieldType{reflect.Uint16, 2, Tags[Tagidx]}
-					case "14":
-						types[Tagidx] = SubFieldType{reflect.Uint32, 4, Tags[Tagidx]}
-					case "21":
-						types[Tagidx] = SubFieldType{reflect.Int8, 1, Tags[Tagidx]}
-					case "22":
-						types[Tagidx] = SubFieldType{reflect.Int16, 2, Tags[Tagidx]}
-					case "24":
-						types[Tagidx] = SubFieldType{reflect.Int32, 4, Tags[Tagidx]}
-					}
-				}
-				Tagidx++
+		} else {
+			group = []SubFieldType{formatType(item)}
+		}
+		size := len(group)
+		if size == 0 {
+			size = 1
+		}
+		if i > (maxFormatTypes-len(types))/size {
+			return nil, false
+		}
+		for ; i > 0; i-- {
+			types = append(types, group...)
+		}
+	}
+	return types, true
+}
+
+// splitFormats splits format controls at the commas that are not within
+// parentheses.
+func splitFormats(controls []byte) [][]byte {
+	var items [][]byte
+	depth, start := 0, 0
+	for i, c := range controls {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, controls[start:i])
+				start = i + 1
 			}
 		}
-		dir.SubFields = types
 	}
-	return dir.SubFields
+	if start < len(controls) {
+		items = append(items, controls[start:])
+	}
+	return items
+}
+
+// formatType returns the SubFieldType of a single format control such as
+// "A", "A(8)", "B(40)" or "b24".
+func formatType(control []byte) SubFieldType {
+	if bytes.IndexByte(control, '&') >= 0 {
+		// Labels embedded in the format controls are not supported, the
+		// subfield would be untagged.
+		return SubFieldType{}
+	}
+	if len(control) == 0 {
+		return SubFieldType{}
+	}
+	var size int
+	if i := bytes.IndexByte(control, '('); i > 0 {
+		// A width of * or none is variable, up to the unit terminator.
+		width := string(bytes.Trim(control[i:], "()"))
+		if width != "*" && width != "" {
+			var err error
+			if size, err = strconv.Atoi(width); err != nil || size < 0 {
+				return SubFieldType{}
+			}
+		}
+	}
+	switch control[0] {
+	case 'A':
+		return SubFieldType{reflect.String, size, nil, false}
+	case 'I':
+		return SubFieldType{reflect.Int, size, nil, false}
+	case 'R':
+		return SubFieldType{reflect.Float64, size, nil, false}
+	case 'B':
+		return SubFieldType{reflect.Array, size / 8, nil, true}
+	case 'b':
+		if len(control) != 3 {
+			break
+		}
+		// The first digit is the type, 1 unsigned, 2 signed or 4 an
+		// IEEE 754 real, the second the width in bytes. Integers of
+		// widths other than 1, 2 and 4 decode as 64 bit integers.
+		if control[2] < '1' || control[2] > '8' {
+			break
+		}
+		width := int(control[2] - '0')
+		switch control[1] {
+		case '1':
+			return SubFieldType{binaryKind(width, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64), width, nil, true}
+		case '2':
+			return SubFieldType{binaryKind(width, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64), width, nil, true}
+		case '4':
+			switch width {
+			case 4:
+				return SubFieldType{reflect.Float32, width, nil, true}
+			case 8:
+				return SubFieldType{reflect.Float64, width, nil, true}
+			}
+		}
+		// Keep unknown binary formats as raw bytes so they are never
+		// scanned for terminators.
+		return SubFieldType{reflect.Array, width, nil, true}
+	}
+	return SubFieldType{}
+}
+
+// binaryKind picks the kind for an integer of width bytes.
+func binaryKind(width int, k8, k16, k32, k64 reflect.Kind) reflect.Kind {
+	switch width {
+	case 1:
+		return k8
+	case 2:
+		return k16
+	case 4:
+		return k32
+	}
+	return k64
 }
 
 // Decode uses the FieldType Format to convert the binary file format
-// SubFields into an array of Go data types.
+// SubFields into an array of Go data types. ASCII numbers, I and R
+// formats, are trimmed of the spaces padding them; a blank or empty one,
+// a value that was not given, is nil rather than 0.
 func (dir FieldType) Decode(buffer []byte) []interface{} {
-	buf := bytes.NewBuffer(buffer)
-	var values []interface{}
-	for buf.Len() > 0 {
-		for _, ftype := range dir.Format() {
+	values, _ := dir.DecodeErr(buffer)
+	return values
+}
+
+// DecodeErr is Decode, but also returns an error describing the first
+// subfield that could not be decoded, with its tag and offset.
+func (dir FieldType) DecodeErr(buffer []byte) ([]interface{}, error) {
+	return dir.decode(buffer)
+}
+
+// DecodeRows is Decode with the SubFields of each repeat of the Format
+// in a row of their own, e.g. one row per YCOO!XCOO pair of a SG2D field.
+// A single repeating tag, e.g. *NAME, has a row for each value. A field
+// that does not repeat, see Repeats, decodes to a single row.
+func (dir FieldType) DecodeRows(buffer []byte) [][]interface{} {
+	rows, _ := dir.decodeRows(buffer)
+	return rows
+}
+
+// DecodeNamedRows is DecodeRows with each row as a map from subfield tag
+// to value, e.g. {"YCOO": int32(389500000), "XCOO": int32(-763000000)}.
+// When a tag appears more than once in the Format the last value of the
+// row wins; use DecodeRows for such fields.
+func (dir FieldType) DecodeNamedRows(buffer []byte) []map[string]interface{} {
+	rows, _ := dir.decodeRows(buffer)
+	types := dir.Format()
+	named := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		named[i] = make(map[string]interface{}, len(row))
+		for j, v := range row {
+			named[i][string(types[j].Tag)] = v
+		}
+	}
+	return named
+}
+
+// decodeRows splits the values of decode into rows of the Format.
+func (dir FieldType) decodeRows(buffer []byte) ([][]interface{}, error) {
+	values, err := dir.decode(buffer)
+	width := len(dir.Format())
+	var rows [][]interface{}
+	for i := 0; i < len(values); i += width {
+		end := i + width
+		if end > len(values) {
+			end = len(values)
+		}
+		rows = append(rows, values[i:end:end])
+	}
+	return rows, err
+}
+
+// decode decodes every subfield, recording the first failure.
+// A failed subfield still has its zero value.
+func (dir FieldType) decode(buffer []byte) ([]interface{}, error) {
+	return dir.decodeAppend(nil, buffer)
+}
+
+// decodeAppend is decode, appending the values to values. A nil values
+// is allocated for the expected number of values.
+func (dir FieldType) decodeAppend(values []interface{}, buffer []byte) ([]interface{}, error) {
+	types := dir.Format()
+	// Size the values for as many repeats as fixed width subfields fit.
+	width := 0
+	for _, ftype := range types {
+		if ftype.Size == 0 {
+			width = 0
+			break
+		}
+		width += ftype.Size
+	}
+	rows := 1
+	if width > 0 {
+		rows = (len(buffer) + width - 1) / width
+	}
+	if values == nil {
+		values = make([]interface{}, 0, rows*len(types))
+	}
+	var first error
+	err := dir.walk(buffer, func(_ SubFieldType, v interface{}, err error) error {
+		values = append(values, v)
+		if err != nil && first == nil {
+			first = err
+		}
+		return nil
+	})
+	if first == nil {
+		first = err
+	}
+	return values, first
+}
+
+// walk decodes each subfield in turn and calls fn with its type, its
+// value and the error decoding it, if any; a subfield that fails has its
+// zero value. It stops and returns the error of fn when there is one,
+// otherwise it returns an error when the format does not fit the buffer.
+func (dir FieldType) walk(buffer []byte, fn func(ftype SubFieldType, value interface{}, err error) error) error {
+	order := dir.byteOrder()
+	types := dir.Format()
+	repeats := dir.Repeats()
+	buf := decoder{data: buffer, unit: dir.Terminators.unit()}
+	for buf.len() > 0 {
+		start := buf.pos
+		for _, ftype := range types {
+			offset := buf.pos
+			var v interface{}
+			var err error
 			switch ftype.Kind {
-			case reflect.Uint8:
-				{
-					var v uint8
-					binary.Read(buf, binary.LittleEndian, &v)
-					values = append(values, v)
-				}
-			case reflect.Uint16:
-				{
-					var v uint16
-					binary.Read(buf, binary.LittleEndian, &v)
-					values = append(values, v)
-				}
-			case reflect.Uint32:
-				{
-					var v uint32
-					binary.Read(buf, binary.LittleEndian, &v)
-					values = append(values, v)
+			case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+				reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				v, err = readInt(&buf, ftype, order)
+			case reflect.Array:
+				b := make([]byte, ftype.Size)
+				if copy(b, buf.next(ftype.Size)) < ftype.Size {
+					err = io.ErrUnexpectedEOF
 				}
-			case reflect.Int8:
-				{
-					var v int8
-					binary.Read(buf, binary.LittleEndian, &v)
-					values = append(values, v)
+				v = b
+			case reflect.Int:
+				v, err = readNumber(&buf, ftype.Size, 0, func(t string) (interface{}, error) {
+					return strconv.Atoi(t)
+				})
+			case reflect.Float32:
+				v, err = readFloat(&buf, ftype, order)
+			case reflect.Float64:
+				if ftype.Binary {
+					v, err = readFloat(&buf, ftype, order)
+					break
 				}
-			case reflect.Int16:
-				{
-					var v int16
-					binary.Read(buf, binary.LittleEndian, &v)
-					values = append(values, v)
-				}
-			case reflect.Int32:
-				{
-					var v int32
-					binary.Read(buf, binary.LittleEndian, &v)
-					values = append(values, v)
+				v, err = readNumber(&buf, ftype.Size, 0.0, func(t string) (interface{}, error) {
+					return strconv.ParseFloat(t, 64)
+				})
+			case reflect.String:
+				if dir.LexicalLevel == 2 {
+					v, err = readUCS2(&buf, ftype.Size, order)
+				} else {
+					v, err = readText(&buf, ftype.Size)
 				}
 			default:
-				{
-					if ftype.Size == 0 {
-						i, _ := buf.ReadString('\x1f')
-						if len(i) > 0 {
-							values = append(values, i[:len(i)-1])
-						} else {
-							values = append(values, "")
-						}
-					} else {
-						i := buf.Next(ftype.Size)
-						values = append(values, string(i))
-					}
-				}
+				v, _ = readText(&buf, ftype.Size)
+				err = errors.New("unknown format")
 			}
+			if err != nil {
+				err = fmt.Errorf("field %s: subfield %s at offset %d: %w", dir.Tag, ftype.Tag, offset, err)
+			}
+			if err := fn(ftype, v, err); err != nil {
+				return err
+			}
+		}
+		if buf.pos == start {
+			// An empty or zero width format would never reach the end.
+			return fmt.Errorf("field %s: the format reads no data at offset %d", dir.Tag, start)
+		}
+		if !repeats {
+			if buf.len() > 0 {
+				return fmt.Errorf("field %s: %d bytes left at offset %d after the subfields of a %v field",
+					dir.Tag, buf.len(), buf.pos, dir.Structure())
+			}
+			break
 		}
 	}
-	return values
+	return nil
+}
+
+// decoder reads the subfields of a field's data in turn.
+type decoder struct {
+	data []byte
+	pos  int
+	unit byte // The unit terminator.
+}
+
+func (d *decoder) len() int {
+	return len(d.data) - d.pos
+}
+
+// next returns the next n bytes, or fewer at the end of the data.
+func (d *decoder) next(n int) []byte {
+	if n > d.len() {
+		n = d.len()
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+// readFloat reads a binary IEEE 754 Float32 or Float64 subfield.
+func readFloat(buf *decoder, ftype SubFieldType, order binary.ByteOrder) (interface{}, error) {
+	b := buf.next(ftype.Size)
+	var err error
+	if len(b) < ftype.Size {
+		err = io.ErrUnexpectedEOF
+		b = make([]byte, ftype.Size)
+	}
+	if ftype.Kind == reflect.Float32 {
+		return math.Float32frombits(order.Uint32(b)), err
+	}
+	return math.Float64frombits(order.Uint64(b)), err
+}
+
+// readNumber reads an ASCII number of size bytes, trimming the spaces
+// that pad it, and parses it with parse. A blank or empty number is nil.
+// A number that cannot be read is zero, with the error.
+func readNumber(buf *decoder, size int, zero interface{}, parse func(string) (interface{}, error)) (interface{}, error) {
+	t, err := readText(buf, size)
+	if err != nil {
+		return zero, err
+	}
+	if t = strings.TrimSpace(t); t == "" {
+		return nil, nil
+	}
+	v, err := parse(t)
+	if err != nil {
+		return zero, err
+	}
+	return v, nil
+}
+
+// readInt reads a binary integer subfield of the Kind of ftype.
+func readInt(buf *decoder, ftype SubFieldType, order binary.ByteOrder) (interface{}, error) {
+	b := buf.next(ftype.Size)
+	var err error
+	if len(b) < ftype.Size {
+		err = io.ErrUnexpectedEOF
+		b = make([]byte, ftype.Size)
+	}
+	switch ftype.Kind {
+	case reflect.Uint8:
+		return b[0], err
+	case reflect.Uint16:
+		return order.Uint16(b), err
+	case reflect.Uint32:
+		return order.Uint32(b), err
+	case reflect.Int8:
+		return int8(b[0]), err
+	case reflect.Int16:
+		return int16(order.Uint16(b)), err
+	case reflect.Int32:
+		return int32(order.Uint32(b)), err
+	}
+	// Other widths are widened to 64 bits.
+	var wide [8]byte
+	var v uint64
+	if order.Uint16([]byte{0, 1}) == 1 {
+		copy(wide[8-ftype.Size:], b)
+		v = binary.BigEndian.Uint64(wide[:])
+	} else {
+		copy(wide[:], b)
+		v = binary.LittleEndian.Uint64(wide[:])
+	}
+	if ftype.Kind == reflect.Int64 {
+		shift := uint(64 - 8*ftype.Size)
+		return int64(v<<shift) >> shift, err
+	}
+	return v, err
+}
+
+// readText reads an ASCII subfield of size bytes, or up to the unit
+// terminator when the size is 0. The end of the field data, where the
+// field terminator was, also ends the last variable width subfield.
+func readText(buf *decoder, size int) (string, error) {
+	if size > 0 {
+		b := buf.next(size)
+		if len(b) < size {
+			return string(b), io.ErrUnexpectedEOF
+		}
+		return string(b), nil
+	}
+	rest := buf.data[buf.pos:]
+	i := bytes.IndexByte(rest, buf.unit)
+	if i < 0 {
+		buf.pos = len(buf.data)
+		return string(rest), nil
+	}
+	buf.pos += i + 1
+	return string(rest[:i]), nil
+}
+
+// readUCS2 reads a lexical level 2 subfield of size bytes, or up to the
+// two byte unit terminator when the size is 0, and returns it as UTF-8.
+func readUCS2(buf *decoder, size int, order binary.ByteOrder) (string, error) {
+	var units []uint16
+	var err error
+	if size > 0 {
+		b := buf.next(size)
+		if len(b) < size {
+			err = io.ErrUnexpectedEOF
+		}
+		for i := 0; i+1 < len(b); i += 2 {
+			units = append(units, order.Uint16(b[i:]))
+		}
+	} else {
+		for buf.len() > 0 {
+			b := buf.next(2)
+			if len(b) < 2 {
+				err = io.ErrUnexpectedEOF
+				break
+			}
+			u := order.Uint16(b)
+			if u == uint16(buf.unit) {
+				break
+			}
+			units = append(units, u)
+		}
+	}
+	return string(utf16.Decode(units)), err
 }
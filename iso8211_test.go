@@ -5,17 +5,26 @@
 package iso8211
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"unicode/utf16"
 )
 
 func TestFieldTypeFormat(t *testing.T) {
 	var f FieldType
 	f.FormatControls = []byte("(A)")
 	v := f.Format()
-	e := SubFieldType{reflect.String, 0, nil}
+	e := SubFieldType{reflect.String, 0, nil, false}
 	if len(v) != 1 || !reflect.DeepEqual(v[0], e) {
 		t.Error("Expected ", e, ", got ", v)
 	}
@@ -24,11 +33,11 @@ func TestFieldTypeFormat(t *testing.T) {
 	f2.ArrayDescriptor = []byte("A!B!C!D!E")
 	v = f2.Format()
 	a := []SubFieldType{
-		{reflect.Uint8, 1, []byte{'A'}},
-		{reflect.Int32, 4, []byte{'B'}},
-		{reflect.Int32, 4, []byte{'C'}},
-		{reflect.String, 3, []byte{'D'}},
-		{reflect.Array, 5, []byte{'E'}}}
+		{reflect.Uint8, 1, []byte{'A'}, true},
+		{reflect.Int32, 4, []byte{'B'}, true},
+		{reflect.Int32, 4, []byte{'C'}, true},
+		{reflect.String, 3, []byte{'D'}, false},
+		{reflect.Array, 5, []byte{'E'}, true}}
 	if len(v) != len(a) {
 		t.Error("Format did not return the expected number of values")
 	} else {
@@ -40,6 +49,632 @@ func TestFieldTypeFormat(t *testing.T) {
 	}
 }
 
+func BenchmarkFieldTypeFormat(b *testing.B) {
+	var f FieldType
+	f.FormatControls = []byte("(b11,b14,2b11,3A,2A(8),R(4),b11,2A,b11,b12,A)")
+	f.ArrayDescriptor = []byte("RCNM!RCID!EXPP!INTU!DSNM!EDTN!UPDN!UADT!ISDT!STED!PRSP!PSDN!PRED!PROF!AGEN!COMT")
+	for i := 0; i < b.N; i++ {
+		f.SubFields = nil
+		f.Format()
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	f, err := os.Open("testdata/US5MD12M.001")
+	if err != nil {
+		b.Fatal("Unexpected error: ", err)
+	}
+	defer f.Close()
+	var l LeadRecord
+	if err = l.Read(f); err != nil {
+		b.Fatal("Error reading the lead record: ", err)
+	}
+	ft := l.FieldTypes["DSID"]
+	data := []byte("\x0a\x01\x00\x00\x00\x02\x05US5MD12M.001\x1f36\x1f1\x1f2012112320121123" +
+		"03.1\x01\x1f2.0\x1f\x02\x26\x02\x1f")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ft.Decode(data)
+	}
+}
+
+func benchmarkReadRecords(b *testing.B, reuse bool) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		b.Fatal("Unexpected error: ", err)
+	}
+	var l LeadRecord
+	if err = l.Read(bytes.NewReader(data)); err != nil {
+		b.Fatal("Error reading the lead record: ", err)
+	}
+	b.ReportAllocs()
+	d := DataRecord{Lead: &l}
+	for i := 0; i < b.N; i++ {
+		r := bytes.NewReader(data[1814:])
+		for {
+			if reuse {
+				d.Reset()
+			} else {
+				d = DataRecord{Lead: &l}
+			}
+			if err := d.Read(r); err == io.EOF {
+				break
+			} else if err != nil {
+				b.Fatal("Unexpected error: ", err)
+			}
+		}
+	}
+}
+
+func BenchmarkReadRecords(b *testing.B)      { benchmarkReadRecords(b, false) }
+func BenchmarkReadRecordsReset(b *testing.B) { benchmarkReadRecords(b, true) }
+
+func benchmarkFileNext(b *testing.B, tags map[string]bool) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		b.Fatal("Unexpected error: ", err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f, err := NewReader(bytes.NewReader(data))
+		if err != nil {
+			b.Fatal("Error reading the lead record: ", err)
+		}
+		f.DecodeTags = tags
+		for {
+			if _, err := f.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				b.Fatal("Unexpected error: ", err)
+			}
+		}
+	}
+}
+
+func BenchmarkFileNext(b *testing.B) { benchmarkFileNext(b, nil) }
+func BenchmarkFileNextDecodeTags(b *testing.B) {
+	benchmarkFileNext(b, map[string]bool{"FRID": true, "FOID": true})
+}
+
+func TestFieldTypeFormatGroups(t *testing.T) {
+	var f FieldType
+	f.FormatControls = []byte("(A(2),2(b11,b12))")
+	f.ArrayDescriptor = []byte("NAME!A!B!C!D")
+	v := f.Format()
+	a := []SubFieldType{
+		{reflect.String, 2, []byte("NAME"), false},
+		{reflect.Uint8, 1, []byte("A"), true},
+		{reflect.Uint16, 2, []byte("B"), true},
+		{reflect.Uint8, 1, []byte("C"), true},
+		{reflect.Uint16, 2, []byte("D"), true}}
+	if !reflect.DeepEqual(v, a) {
+		t.Error("Expected ", a, ", got ", v)
+	}
+	f = FieldType{}
+	f.FormatControls = []byte("(b11,2b12)")
+	f.ArrayDescriptor = []byte("FSUI!FSIX!NSPT")
+	v = f.Format()
+	a = []SubFieldType{
+		{reflect.Uint8, 1, []byte("FSUI"), true},
+		{reflect.Uint16, 2, []byte("FSIX"), true},
+		{reflect.Uint16, 2, []byte("NSPT"), true}}
+	if !reflect.DeepEqual(v, a) {
+		t.Error("Expected ", a, ", got ", v)
+	}
+	// Repeat counts that expand too far are invalid, not allocated.
+	for _, controls := range []string{"(99999999b11)", "(999(999(999b11)))", "(99999999999999999999b11)"} {
+		if v = parseFormats([]byte(controls)); len(v) != 1 || v[0].Kind != reflect.Invalid {
+			t.Error("Expected an invalid format for ", controls, ", got ", len(v), " subfields")
+		}
+	}
+	if v = parseFormats([]byte("(2(3(b11)))")); len(v) != 6 {
+		t.Error("Expected 6 subfields, got ", v)
+	}
+}
+
+func TestFieldTypeFormatCycles(t *testing.T) {
+	var f FieldType
+	f.FormatControls = []byte("(I(3))")
+	f.ArrayDescriptor = []byte("A!B!C")
+	v := f.Format()
+	a := []SubFieldType{
+		{reflect.Int, 3, []byte("A"), false},
+		{reflect.Int, 3, []byte("B"), false},
+		{reflect.Int, 3, []byte("C"), false}}
+	if !reflect.DeepEqual(v, a) {
+		t.Error("Expected ", a, ", got ", v)
+	}
+}
+
+func TestFieldTypeFormatInteger(t *testing.T) {
+	var f FieldType
+	f.FormatControls = []byte("(I(5),A)")
+	f.ArrayDescriptor = []byte("NUMB!TEXT")
+	v := f.Format()
+	a := []SubFieldType{
+		{reflect.Int, 5, []byte("NUMB"), false},
+		{reflect.String, 0, []byte("TEXT"), false}}
+	if !reflect.DeepEqual(v, a) {
+		t.Error("Expected ", a, ", got ", v)
+	}
+	d := f.Decode([]byte(" -123abc\x1f42   de\x1f"))
+	e := []interface{}{-123, "abc", 42, "de"}
+	if !reflect.DeepEqual(d, e) {
+		t.Errorf("Expected %q, got %q", e, d)
+	}
+}
+
+func TestFieldTypeFormatReal(t *testing.T) {
+	var f FieldType
+	f.FormatControls = []byte("(R(4),R)")
+	f.ArrayDescriptor = []byte("STED!DEPT")
+	v := f.Format()
+	a := []SubFieldType{
+		{reflect.Float64, 4, []byte("STED"), false},
+		{reflect.Float64, 0, []byte("DEPT"), false}}
+	if !reflect.DeepEqual(v, a) {
+		t.Error("Expected ", a, ", got ", v)
+	}
+	d := f.Decode([]byte("03.1-12.75\x1f"))
+	e := []interface{}{3.1, -12.75}
+	if !reflect.DeepEqual(d, e) {
+		t.Error("Expected ", e, ", got ", d)
+	}
+}
+
+func TestFieldTypeFormatVariableWidth(t *testing.T) {
+	var f FieldType
+	f.FormatControls = []byte("(A(*),I(2),A(x))")
+	f.ArrayDescriptor = []byte("NAME!NUMB!BAD")
+	v := f.Format()
+	a := []SubFieldType{
+		{reflect.String, 0, []byte("NAME"), false},
+		{reflect.Int, 2, []byte("NUMB"), false},
+		{reflect.Invalid, 0, []byte("BAD"), false}}
+	if !reflect.DeepEqual(v, a) {
+		t.Error("Expected ", a, ", got ", v)
+	}
+	f = FieldType{FormatControls: []byte("(A(*),I(2))"), ArrayDescriptor: []byte("NAME!NUMB")}
+	d := f.Decode([]byte("Chart\x1f12"))
+	e := []interface{}{"Chart", 12}
+	if !reflect.DeepEqual(d, e) {
+		t.Error("Expected ", e, ", got ", d)
+	}
+}
+
+func TestDecodeUnterminatedText(t *testing.T) {
+	var f FieldType
+	f.FormatControls = []byte("(I(2),A)")
+	f.ArrayDescriptor = []byte("NUMB!NAME")
+	// The field terminator, already stripped, ends the last subfield.
+	for _, data := range []string{"12Chart", "12Chart\x1f"} {
+		d := f.Decode([]byte(data))
+		e := []interface{}{12, "Chart"}
+		if !reflect.DeepEqual(d, e) {
+			t.Errorf("Decoding %q expected %v, got %v", data, e, d)
+		}
+	}
+}
+
+func TestFieldTypeFormatEmpty(t *testing.T) {
+	var f FieldType
+	f.FormatControls = []byte("()")
+	f.ArrayDescriptor = []byte("NAME!COMT")
+	v := f.Format()
+	a := []SubFieldType{
+		{reflect.String, 0, []byte("NAME"), false},
+		{reflect.String, 0, []byte("COMT"), false}}
+	if !reflect.DeepEqual(v, a) {
+		t.Error("Expected ", a, ", got ", v)
+	}
+	d := f.Decode([]byte("Chart\x1fUpdated\x1f"))
+	e := []interface{}{"Chart", "Updated"}
+	if !reflect.DeepEqual(d, e) {
+		t.Error("Expected ", e, ", got ", d)
+	}
+}
+
+func TestDecodeByteOrder(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		var buf bytes.Buffer
+		binary.Write(&buf, order, int32(-123456789))
+		binary.Write(&buf, order, uint16(4321))
+		f := FieldType{ByteOrder: order}
+		f.FormatControls = []byte("(b24,b12)")
+		f.ArrayDescriptor = []byte("YCOO!AGEN")
+		v := f.Decode(buf.Bytes())
+		e := []interface{}{int32(-123456789), uint16(4321)}
+		if !reflect.DeepEqual(v, e) {
+			t.Error(order, " expected ", e, ", got ", v)
+		}
+	}
+}
+
+func TestDecodeBinaryArray(t *testing.T) {
+	var f FieldType
+	f.FormatControls = []byte("(B(40))")
+	f.ArrayDescriptor = []byte("*NAME")
+	data := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	v := f.Decode(data)
+	e := []interface{}{[]byte{1, 2, 3, 4, 5}, []byte{6, 7, 8, 9, 10}}
+	if !reflect.DeepEqual(v, e) {
+		t.Error("Expected ", e, ", got ", v)
+	}
+	data[0] = 0
+	if v[0].([]byte)[0] != 1 {
+		t.Error("Decoded bytes share the buffer")
+	}
+}
+
+func TestDecodeBinaryReal(t *testing.T) {
+	var f FieldType
+	f.FormatControls = []byte("(b44,b48,R(4))")
+	f.ArrayDescriptor = []byte("*FLT!DBL!TXT")
+	e := []SubFieldType{
+		{reflect.Float32, 4, []byte("FLT"), true},
+		{reflect.Float64, 8, []byte("DBL"), true},
+		{reflect.Float64, 4, []byte("TXT"), false}}
+	if v := f.Format(); !reflect.DeepEqual(v, e) {
+		t.Error("Expected ", e, ", got ", v)
+	}
+	values := []interface{}{float32(1.5), -2.25, 0.5, float32(-0.1), 1e300, 10.0}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		var buf bytes.Buffer
+		binary.Write(&buf, order, float32(1.5))
+		binary.Write(&buf, order, -2.25)
+		buf.WriteString("00.5")
+		binary.Write(&buf, order, float32(-0.1))
+		binary.Write(&buf, order, 1e300)
+		buf.WriteString("0010")
+		f.ByteOrder = order
+		v, err := f.DecodeErr(buf.Bytes())
+		if err != nil || !reflect.DeepEqual(v, values) {
+			t.Error("Expected ", values, ", got ", v, err)
+		}
+		b, err := f.Encode(values)
+		if err != nil || !bytes.Equal(b, buf.Bytes()) {
+			t.Errorf("Expected %q, got %q %v", buf.Bytes(), b, err)
+		}
+	}
+}
+
+func TestDecodeRows(t *testing.T) {
+	var f FieldType
+	f.FormatControls = []byte("(2b24)")
+	f.ArrayDescriptor = []byte("*YCOO!XCOO")
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []int32{389500000, -763000000, 389600000, -763100000})
+	v := f.DecodeRows(buf.Bytes())
+	e := [][]interface{}{
+		{int32(389500000), int32(-763000000)},
+		{int32(389600000), int32(-763100000)}}
+	if !reflect.DeepEqual(v, e) {
+		t.Error("Expected ", e, ", got ", v)
+	}
+	if d := f.Decode(buf.Bytes()); len(d) != 4 || d[2] != int32(389600000) {
+		t.Error("Expected 4 flattened values, got ", d)
+	}
+}
+
+func TestDecodeRowsSingleTag(t *testing.T) {
+	var f FieldType
+	f.DataStructure = '1'
+	f.FormatControls = []byte("(A)")
+	f.ArrayDescriptor = []byte("*NAME")
+	data := []byte("one\x1ftwo\x1fthree\x1f")
+	e := [][]interface{}{{"one"}, {"two"}, {"three"}}
+	if v := f.DecodeRows(data); !reflect.DeepEqual(v, e) {
+		t.Error("Expected ", e, ", got ", v)
+	}
+	if v := f.DecodeNamedRows(data); len(v) != 3 || v[2]["NAME"] != "three" {
+		t.Error("Expected 3 named rows, got ", v)
+	}
+}
+
+func TestDecodeNamedRows(t *testing.T) {
+	var f FieldType
+	f.FormatControls = []byte("(b12,A,b11)")
+	f.ArrayDescriptor = []byte("*ATTL!ATVL!ATTL")
+	v := f.DecodeNamedRows([]byte{178, 0, '5', 0x1f, 1, 147, 0, 'x', 0x1f, 2})
+	e := []map[string]interface{}{
+		{"ATTL": uint8(1), "ATVL": "5"},
+		{"ATTL": uint8(2), "ATVL": "x"}}
+	if !reflect.DeepEqual(v, e) {
+		t.Error("Expected ", e, ", got ", v)
+	}
+	if v := f.DecodeNamedRows(nil); len(v) != 0 {
+		t.Error("Expected no rows, got ", v)
+	}
+}
+
+func TestDecodeRowsStructure(t *testing.T) {
+	var f FieldType
+	f.FormatControls = []byte("(b11,b12)")
+	f.ArrayDescriptor = []byte("AAAA!BBBB")
+	data := []byte{1, 2, 0, 3, 4, 0}
+	for _, c := range []struct {
+		structure byte
+		rows      int
+	}{{'1', 1}, {'2', 2}, {0, 2}} {
+		f.DataStructure = c.structure
+		v, err := f.decodeRows(data)
+		if len(v) != c.rows || (err != nil) != (c.rows == 1) {
+			t.Error("Structure ", f.Structure(), " expected ", c.rows, " rows, got ", v, err)
+		}
+	}
+	f.DataStructure = '1'
+	f.ArrayDescriptor = []byte("*AAAA!BBBB")
+	if v := f.DecodeRows(data); len(v) != 2 {
+		t.Error("Expected a * descriptor to repeat, got ", v)
+	}
+}
+
+func TestDecodeLexicalLevel2(t *testing.T) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint16(301))
+	binary.Write(&buf, binary.LittleEndian, utf16.Encode([]rune("Zürich 東京")))
+	binary.Write(&buf, binary.LittleEndian, []uint16{0x1f, 0x1e})
+	var f Field
+	f.Length = buf.Len()
+	f.FieldType = FieldType{Tag: "NATF", LexicalLevel: 2}
+	f.FieldType.FormatControls = []byte("(b12,A)")
+	f.FieldType.ArrayDescriptor = []byte("*ATTL!ATVL")
+	if err := f.Read(&buf); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	e := []interface{}{uint16(301), "Zürich 東京"}
+	if !reflect.DeepEqual(f.SubFields, e) {
+		t.Error("Expected ", e, ", got ", f.SubFields)
+	}
+}
+
+func TestFieldTypeReadEscapeSequence(t *testing.T) {
+	for esc, e := range map[string]int{"   ": 0, "-A ": 1, "%/A": 2, "XYZ": 0} {
+		data := "1600;&" + esc + "National attributes\x1f*ATTL!ATVL\x1f(b12,A)\x1e"
+		f := FieldType{Tag: "NATF", Length: len(data)}
+		if err := f.Read(bytes.NewReader([]byte(data))); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		if f.LexicalLevel != e {
+			t.Error("Expected lexical level ", e, " for ", esc, ", got ", f.LexicalLevel)
+		}
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint16(301))
+	binary.Write(&buf, binary.LittleEndian, utf16.Encode([]rune("東京")))
+	binary.Write(&buf, binary.LittleEndian, []uint16{0x1f})
+	data := "1600;&%/ANational attributes\x1f*ATTL!ATVL\x1f(b12,A)\x1e"
+	f := FieldType{Tag: "NATF", Length: len(data)}
+	f.Read(bytes.NewReader([]byte(data)))
+	e := []interface{}{uint16(301), "東京"}
+	if v := f.Decode(buf.Bytes()); !reflect.DeepEqual(v, e) {
+		t.Error("Expected ", e, ", got ", v)
+	}
+	if d := f.encodeDescription(9); !bytes.HasPrefix(d, []byte("1600;&%/A")) {
+		t.Error("Expected the escape sequence to be written, got ", string(d))
+	}
+}
+
+func TestDecodeErr(t *testing.T) {
+	var f FieldType
+	f.Tag = "FOID"
+	f.FormatControls = []byte("(b12,b14,b12)")
+	f.ArrayDescriptor = []byte("AGEN!FIDN!FIDS")
+	v, err := f.DecodeErr([]byte{0x26, 0x02, 0x57, 0x46, 0x85, 0x00, 0x32})
+	if err == nil || err.Error() != "field FOID: subfield FIDS at offset 6: unexpected EOF" {
+		t.Error("Expected a FIDS error, got ", err)
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Error("Expected the error to wrap io.ErrUnexpectedEOF, got ", err)
+	}
+	if len(v) != 3 || v[0] != uint16(550) || v[1] != uint32(8734295) {
+		t.Error("Expected the values before FIDS, got ", v)
+	}
+	if _, err = f.DecodeErr([]byte{0x26, 0x02, 0x57, 0x46, 0x85, 0x00, 0x32, 0x00}); err != nil {
+		t.Error("Unexpected error: ", err)
+	}
+}
+
+func TestDecodePaddedNumbers(t *testing.T) {
+	f := FieldType{Tag: "TEST", ArrayDescriptor: []byte("RGHT!LEFT!BLNK!REAL!NONE!VARI!EMPT"),
+		FormatControls: []byte("(3I(4),2R(6),I,R)")}
+	v, err := f.DecodeErr([]byte("  1212      -1.5        -7\x1f\x1f"))
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	e := []interface{}{12, 12, nil, -1.5, nil, -7, nil}
+	if !reflect.DeepEqual(v, e) {
+		t.Errorf("Expected %#v, got %#v", e, v)
+	}
+	b, err := f.Encode(v)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if string(b) != "00120012    -001.5      -7\x1f\x1f" {
+		t.Errorf("Expected the nil numbers to be blank, got %q", b)
+	}
+	var s struct {
+		BLNK int     `iso8211:"BLNK"`
+		NONE float64 `iso8211:"NONE"`
+	}
+	s.BLNK, s.NONE = 1, 1
+	if err = decodeInto(f.Tag, f.Format(), v, &s); err != nil || s.BLNK != 0 || s.NONE != 0 {
+		t.Error("Expected the blank numbers to be zero, got ", s, err)
+	}
+	if v, err = f.DecodeErr([]byte("  x1")); err == nil || v[0] != 0 {
+		t.Error("Expected a zero with an error, got ", v, err)
+	}
+}
+
+func TestEncodeWidths(t *testing.T) {
+	f := FieldType{Tag: "TEST", ArrayDescriptor: []byte("NUMB!NAME!TEXT"), FormatControls: []byte("(I(3),A(2),A)")}
+	for _, v := range [][]interface{}{
+		{12345, "ab", ""},
+		{1, "hello", ""},
+		{1, "ab", "a\x1fb"},
+		{1, "ab", "a\x1eb"},
+	} {
+		if b, err := f.Encode(v); err == nil {
+			t.Errorf("Expected an error for %q, got %q", v, b)
+		}
+	}
+	f.LexicalLevel = 2
+	f.FormatControls = []byte("(I(3),A(5),A)")
+	b, err := f.Encode([]interface{}{1, "ab", "c"})
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if len(b) != 3+5+4 {
+		t.Errorf("Expected 12 bytes, got %q", b)
+	}
+	if _, err = f.Encode([]interface{}{1, "abc", "c"}); err == nil {
+		t.Error("Expected an error for 6 bytes of text in 5")
+	}
+	if _, err = f.Encode([]interface{}{1, "ab", "c\x1f"}); err == nil {
+		t.Error("Expected an error for the unit terminator")
+	}
+}
+
+func TestDecodeBinaryTerminatorBytes(t *testing.T) {
+	var f FieldType
+	f.Tag = "TEST"
+	f.ArrayDescriptor = []byte("NAME!VALU!RAWB!TEXT")
+	f.FormatControls = []byte("(A,b14,b33,A)")
+	data := []byte("ab\x1f\x1f\x1e\x1f\x1e\x1f\x1e\x1fcd\x1f")
+	v := f.Decode(data)
+	e := []interface{}{"ab", uint32(0x1e1f1e1f), []byte{0x1f, 0x1e, 0x1f}, "cd"}
+	if !reflect.DeepEqual(v, e) {
+		t.Errorf("Expected %q, got %q", e, v)
+	}
+}
+
+func TestDecodeConcurrent(t *testing.T) {
+	records := readTestRecords(t)
+	attf, _ := records[1].Field("ATTF")
+	data, err := attf.FieldType.Encode(attf.SubFields)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	// A lead record of its own, so the goroutines parse the format.
+	f, err := os.Open("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	defer f.Close()
+	var lead LeadRecord
+	if err = lead.Read(f); err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	ft := lead.FieldTypes["ATTF"]
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			shared := &ft
+			if v := shared.Decode(data); !reflect.DeepEqual(v, attf.SubFields) {
+				t.Error("Expected ", attf.SubFields, ", got ", v)
+			}
+			if len(shared.Format()) != 2 {
+				t.Error("Expected 2 subfield types, got ", shared.Format())
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestDecodeNoProgress(t *testing.T) {
+	for _, controls := range []string{"", "(B(0))"} {
+		f := FieldType{Tag: "TEST", ArrayDescriptor: []byte("DATA"), FormatControls: []byte(controls)}
+		v, err := f.DecodeErr([]byte("abc"))
+		if err == nil || !strings.Contains(err.Error(), "reads no data") {
+			t.Error("Format ", controls, " expected a no progress error, got ", v, err)
+		}
+	}
+}
+
+func TestDecodeWideBinary(t *testing.T) {
+	var f FieldType
+	f.Tag = "TEST"
+	f.ArrayDescriptor = []byte("UN3!SN3!UN8!SN8")
+	f.FormatControls = []byte("(b13,b23,b18,b28)")
+	data := []byte{
+		0x01, 0x02, 0x03,
+		0xfe, 0xff, 0xff,
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	}
+	v, err := f.DecodeErr(data)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	e := []interface{}{uint64(0x030201), int64(-2), uint64(0x0807060504030201), int64(-1)}
+	if !reflect.DeepEqual(v, e) {
+		t.Error("Expected ", e, ", got ", v)
+	}
+	b, err := f.Encode(v)
+	if err != nil || !bytes.Equal(b, data) {
+		t.Error("Expected ", data, ", got ", b, err)
+	}
+	f.ByteOrder = binary.BigEndian
+	f.SubFields = nil
+	v = f.Decode(data[:6])
+	if e := []interface{}{uint64(0x010203), int64(-65537)}; !reflect.DeepEqual(v[:2], e) {
+		t.Error("Expected ", e, ", got ", v)
+	}
+}
+
+func TestFieldTypeReadMalformed(t *testing.T) {
+	data := "1600;&   Name without descriptor\x1e"
+	f := FieldType{Tag: "TEST", Length: len(data)}
+	err := f.Read(bytes.NewReader([]byte(data)))
+	if err == nil || !strings.Contains(err.Error(), "TEST") {
+		t.Error("Expected an error naming TEST, got ", err)
+	}
+	if string(f.Name) != "Name without descriptor" || f.ArrayDescriptor != nil || f.FormatControls != nil {
+		t.Error("Unexpected field type ", f)
+	}
+	f = FieldType{Tag: "TEST", Length: 5}
+	if err = f.Read(bytes.NewReader([]byte(data))); err == nil {
+		t.Error("Expected an error for a short field description")
+	}
+}
+
+func TestFieldTypeReadControlLength(t *testing.T) {
+	for _, c := range []struct {
+		controls int
+		data     string
+	}{
+		{6, "1600;&Test\x1fNAME!NUMB\x1f(A,I(2))\x1e"},
+		{9, "1600;&   Test\x1fNAME!NUMB\x1f(A,I(2))\x1e"},
+		{12, "1600;&   XYZTest\x1fNAME!NUMB\x1f(A,I(2))\x1e"},
+		{9, "1600;&   Test\x1fNAME!NUMB\x1f(A,I(2))\x1e  "},
+	} {
+		f := FieldType{Tag: "AB", Length: len(c.data), controlLength: c.controls}
+		if err := f.Read(bytes.NewReader([]byte(c.data))); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		if string(f.Name) != "Test" || string(f.ArrayDescriptor) != "NAME!NUMB" || string(f.FormatControls) != "(A,I(2))" ||
+			f.PrintableUt != '&' {
+			t.Error("Unexpected field type for ", c.controls, " controls ", f)
+		}
+	}
+}
+
+func TestFieldTypeReadExtraParts(t *testing.T) {
+	data := "1600;&   Test\x1fNAME!NUMB\x1f(A,I(2))\x1fextra\x1f\x1e"
+	f := FieldType{Tag: "AB", Length: len(data)}
+	if err := f.Read(bytes.NewReader([]byte(data))); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if string(f.FormatControls) != "(A,I(2))" || len(f.Extra) != 2 || string(f.Extra[0]) != "extra" {
+		t.Errorf("Unexpected format controls %q and extra parts %q", f.FormatControls, f.Extra)
+	}
+	if d := f.encodeDescription(9); string(d) != data {
+		t.Errorf("Expected %q, got %q", data, d)
+	}
+}
+
 func TestS57File(t *testing.T) {
 	f, err := os.Open("testdata/US5MD12M.001")
 	if err != nil {
@@ -67,8 +702,904 @@ func TestS57File(t *testing.T) {
 	if len(d.Fields[3].SubFields) != 6 && d.Fields[3].SubFields[4] != 148 {
 		t.Error("Data record 2, Field 4 is not what we expected.", d.Fields[3])
 	}
-	if d.Read(f) == nil {
-		t.Error("Should be at EOF")
+	if err = d.Read(f); err != io.EOF {
+		t.Error("Should be at EOF, got ", err)
+	}
+}
+
+func TestLeadRecordTagPairs(t *testing.T) {
+	f, err := os.Open("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	defer f.Close()
+	var l LeadRecord
+	if err = l.Read(f); err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	if len(l.TagPairs) != 17 {
+		t.Fatal("Expected 17 tag pairs, got ", l.TagPairs)
+	}
+	e := []TagPair{{"0001", "DSID"}, {"DSID", "DSSI"}, {"0001", "FRID"}}
+	if !reflect.DeepEqual(l.TagPairs[:3], e) {
+		t.Error("Expected ", e, ", got ", l.TagPairs[:3])
+	}
+	delete(l.FieldTypes, "0000")
+	if p := l.readTagPairs(); p != nil {
+		t.Error("Expected no tag pairs without a 0000 field, got ", p)
+	}
+}
+
+func TestLeadRecordFieldSchema(t *testing.T) {
+	records := readTestRecords(t)
+	l := records[0].Lead
+	types, err := l.FieldSchema("FOID")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	e := []SubFieldType{
+		{reflect.Uint16, 2, []byte("AGEN"), true},
+		{reflect.Uint32, 4, []byte("FIDN"), true},
+		{reflect.Uint16, 2, []byte("FIDS"), true},
+	}
+	if !reflect.DeepEqual(types, e) {
+		t.Error("Expected ", e, ", got ", types)
+	}
+	types[0].Size = 7
+	if types, _ = l.FieldSchema("FOID"); types[0].Size != 2 {
+		t.Error("Expected a copy of the subfield types, got ", types)
+	}
+	if types, err = l.FieldSchema("0000"); err != nil || len(types) != 0 {
+		t.Error("Expected no subfield types, got ", types, err)
+	}
+	if _, err = l.FieldSchema("NONE"); err == nil {
+		t.Error("Expected an error for a missing field type")
+	}
+	l = &LeadRecord{FieldTypes: map[string]FieldType{"TEST": {Tag: "TEST", ArrayDescriptor: []byte("A"), FormatControls: []byte("(Q)")}}}
+	if _, err = l.FieldSchema("TEST"); err == nil {
+		t.Error("Expected an error for the unknown format control")
+	}
+}
+
+func TestLeadRecordValidate(t *testing.T) {
+	f, err := os.Open("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	defer f.Close()
+	var l LeadRecord
+	if err = l.Read(f); err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	if err = l.Validate(); err != nil {
+		t.Error("Unexpected error: ", err)
+	}
+	l.FieldTypes["FOID"] = FieldType{Tag: "FOID", ArrayDescriptor: []byte("AGEN!FIDN!FIDS"), FormatControls: []byte("(b12,b14)")}
+	l.FieldTypes["SG2D"] = FieldType{Tag: "SG2D", ArrayDescriptor: []byte("*YCOO!XCOO"), FormatControls: []byte("(2x24)")}
+	l.FieldTypes["NATF"] = FieldType{Tag: "NATF", FormatControls: []byte("(b12&ATTL,A&ATVL)")}
+	err = l.Validate()
+	if err == nil || !strings.Contains(err.Error(), "field FOID: 3 tags") || !strings.Contains(err.Error(), "field SG2D: unknown format control") ||
+		!strings.Contains(err.Error(), "field NATF: subfield labels in the format controls") {
+		t.Error("Expected FOID, NATF and SG2D errors, got ", err)
+	}
+	natf := l.FieldTypes["NATF"]
+	if _, err = natf.DecodeErr([]byte{1, 2, 'x', 0x1f}); err == nil || !strings.Contains(err.Error(), "unknown format") {
+		t.Error("Expected an unknown format error for labelled format controls, got ", err)
+	}
+	for _, controls := range []string{"(b1/)", "(b1x)", "(b10)", "(b19)"} {
+		l.FieldTypes = map[string]FieldType{"TEST": {Tag: "TEST", ArrayDescriptor: []byte("NUMB"), FormatControls: []byte(controls)}}
+		if err = l.Validate(); err == nil || !strings.Contains(err.Error(), "field TEST: unknown format control") {
+			t.Error("Expected an unknown format control error for ", controls, ", got ", err)
+		}
+	}
+}
+
+// chunkReader returns the data of r in chunks of the sizes in turn, and
+// nothing for a size of 0, so reads end at awkward boundaries. It is not
+// an io.Seeker.
+type chunkReader struct {
+	r     io.Reader
+	sizes []int
+	i     int
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	size := c.sizes[c.i%len(c.sizes)]
+	c.i++
+	if size == 0 {
+		return 0, nil
+	}
+	if len(p) > size {
+		p = p[:size]
+	}
+	return c.r.Read(p)
+}
+
+// chunkReaders returns readers of data with pathological chunk sizes,
+// some returning io.EOF with the last data.
+func chunkReaders(data []byte) map[string]func() io.Reader {
+	readers := make(map[string]func() io.Reader)
+	for name, sizes := range map[string][]int{
+		"one byte":  {1},
+		"primes":    {2, 3, 5, 7, 11, 13},
+		"empty":     {0, 1, 0, 24, 0, 0, 4096},
+		"leader":    {23, 1, 25},
+		"large":     {1 << 16},
+		"alternate": {1, 1000},
+	} {
+		sizes := sizes
+		readers[name] = func() io.Reader {
+			return &chunkReader{r: bytes.NewReader(data), sizes: sizes}
+		}
+		readers[name+" data err"] = func() io.Reader {
+			return iotest.DataErrReader(&chunkReader{r: bytes.NewReader(data), sizes: sizes})
+		}
+	}
+	return readers
+}
+
+func TestChunkedReads(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	want := readTestRecords(t)
+	for name, reader := range chunkReaders(data) {
+		r := reader()
+		var l LeadRecord
+		if err = l.Read(r); err != nil {
+			t.Fatal(name, ": error reading the lead record: ", err)
+		}
+		for i := range want {
+			d := DataRecord{Lead: &l}
+			if err = d.Read(r); err != nil {
+				t.Fatal(name, ": error reading data record ", i+1, ": ", err)
+			}
+			if d.String() != want[i].String() {
+				t.Error(name, ": data record ", i+1, " is not what we expected: ", d.Fields)
+			}
+		}
+		if err = (&DataRecord{Lead: &l}).Read(r); err != io.EOF {
+			t.Error(name, ": expected io.EOF, got ", err)
+		}
+
+		for _, settings := range []File{{}, {Lazy: true, KeepRaw: true}, {Recover: true}} {
+			f, err := NewReader(reader())
+			if err != nil {
+				t.Fatal(name, ": error reading the lead record: ", err)
+			}
+			f.Lazy, f.KeepRaw, f.Recover = settings.Lazy, settings.KeepRaw, settings.Recover
+			n := 0
+			for {
+				d, err := f.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(name, ": unexpected error: ", err)
+				}
+				if err = d.Decode(); err != nil || d.String() != want[n].String() || d.Header.Offset != want[n].Header.Offset {
+					t.Error(name, ": unexpected record ", d.Header.Offset, err)
+				}
+				n++
+			}
+			if s := f.Stats(); n != 2 || s.Bytes != int64(len(data)) || s.Skipped != 0 {
+				t.Error(name, ": expected 2 records, got ", n, s)
+			}
+		}
+
+		f, err := NewReader(reader())
+		if err != nil {
+			t.Fatal(name, ": error reading the lead record: ", err)
+		}
+		if err = f.Skip(1); err != nil {
+			t.Error(name, ": unexpected error: ", err)
+		}
+		counts, err := f.TagCounts()
+		if err != nil || counts["ATTF"] != 1 {
+			t.Error(name, ": unexpected counts ", counts, err)
+		}
+
+		report, err := Conformance(reader())
+		if err != nil || report.Records != 2 {
+			t.Error(name, ": unexpected report ", report, err)
+		}
+		if p, err := Detect(reader()); err != nil || p.Kind != S57Update {
+			t.Error(name, ": unexpected profile ", p, err)
+		}
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	// The lead record is 1814 bytes with its field area at 234, data
+	// record 2 starts at 1958. records is the data records read before
+	// the cut, -1 when the lead record is cut.
+	tests := []struct {
+		name    string
+		n       int
+		records int
+		msg     string
+	}{
+		{"leader", 10, -1, ""},
+		{"end of leader", 24, -1, ""},
+		{"lead directory", 100, -1, ""},
+		{"lead field data", 1000, -1, ""},
+		{"data directory", 1958 + 30, 1, ""},
+		{"data field data", len(data) - 10, 1, "field ATTF at offset"},
+		{"none", len(data), 2, ""},
+	}
+	for _, test := range tests {
+		// Reading a byte at a time must find the same records.
+		r := iotest.OneByteReader(bytes.NewReader(data[:test.n]))
+		var l LeadRecord
+		err = l.Read(r)
+		if test.records < 0 {
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Error(test.name, ": lead record expected io.ErrUnexpectedEOF, got ", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(test.name, ": error reading the lead record: ", err)
+		}
+		if len(l.FieldTypes) != 19 {
+			t.Error(test.name, ": expected 19 field types, got ", len(l.FieldTypes))
+		}
+		d := DataRecord{Lead: &l}
+		for i := 1; i <= test.records; i++ {
+			if err = d.Read(r); err != nil {
+				t.Fatal(test.name, ": error reading Data record ", i, ": ", err)
+			}
+			if d.Fields[0].SubFields[0] != uint16(i) {
+				t.Error(test.name, ": expected record ", i, ", got ", d.Fields[0].SubFields[0])
+			}
+		}
+		if test.records == 2 {
+			if s := d.Fields[3].SubFields[5]; s != "US,US,reprt,5thCGD,LNM 46/12" {
+				t.Error("Data record 2 ATTF is not what we expected: ", s)
+			}
+			if err = d.Read(r); err != io.EOF {
+				t.Error(test.name, ": expected io.EOF, got ", err)
+			}
+			continue
+		}
+		if err = d.Read(r); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Error(test.name, ": data record expected io.ErrUnexpectedEOF, got ", err)
+		} else if !strings.Contains(err.Error(), test.msg) {
+			t.Error(test.name, ": expected ", test.msg, ", got ", err)
+		}
+	}
+}
+
+func TestFieldSubField(t *testing.T) {
+	var f Field
+	f.FieldType.FormatControls = []byte("(b12,A)")
+	f.FieldType.ArrayDescriptor = []byte("*ATTL!ATVL")
+	f.SubFields = []interface{}{uint16(178), "5", uint16(147), "20121113"}
+	if v, ok := f.SubField("ATVL"); !ok || v != "5" {
+		t.Error("Expected ATVL 5, got ", v)
+	}
+	if v, ok := f.SubField("NONE"); ok {
+		t.Error("Expected no NONE subfield, got ", v)
+	}
+	e := []interface{}{uint16(178), uint16(147)}
+	if v := f.SubFieldValues("ATTL"); !reflect.DeepEqual(v, e) {
+		t.Error("Expected ", e, ", got ", v)
+	}
+	// Without a format the subfields have no tags.
+	f.FieldType = FieldType{}
+	if v, ok := f.SubField("ATVL"); ok {
+		t.Error("Expected no ATVL subfield without a format, got ", v)
+	}
+	if v := f.SubFieldValues("ATTL"); v != nil {
+		t.Error("Expected no ATTL subfields without a format, got ", v)
+	}
+	setSubField(&f, "ATVL", "6")
+	if f.SubFields[1] != "5" {
+		t.Error("Expected the subfields unchanged, got ", f.SubFields)
+	}
+}
+
+func TestDataRecordString(t *testing.T) {
+	d := readTestRecords(t)[1]
+	e := `0001: 2
+FRID: RCNM=100 RCID=1357 PRIM=1 GRUP=2 OBJL=75 RVER=2 RUIN=3
+FOID: AGEN=550 FIDN=8734295 FIDS=50
+ATTF: ATTL=178 ATVL="5"; ATTL=147 ATVL="20121113"; ATTL=148 ATVL="US,US,reprt,5thCGD,LNM 46/12"`
+	if s := d.String(); s != e {
+		t.Error("Expected ", e, ", got ", s)
+	}
+}
+
+func TestFieldStringPrintable(t *testing.T) {
+	ft := FieldType{Tag: "TEST", ArrayDescriptor: []byte("TEXT"), FormatControls: []byte("(A(5))")}
+	f := Field{Tag: "TEST", FieldType: ft, SubFields: []interface{}{"a\x1fb\x1ec"}}
+	if s := f.String(); s != `TEST: TEXT="a\x1fb\x1ec"` {
+		t.Error("Expected escaped terminators, got ", s)
+	}
+	f.FieldType.PrintableFt, f.FieldType.PrintableUt = ';', '&'
+	if s := f.String(); s != `TEST: TEXT="a&b;c"` {
+		t.Error("Expected printable terminators, got ", s)
+	}
+	f.FieldType.Terminators = Terminators{Field: '~', Unit: '|'}
+	f.SubFields = []interface{}{"a|b~c\x1f"}
+	if s := f.String(); s != `TEST: TEXT="a&b;c\x1f"` {
+		t.Error("Expected printable custom terminators, got ", s)
+	}
+}
+
+func TestDataRecordField(t *testing.T) {
+	var d DataRecord
+	d.Fields = []Field{{Tag: "0001"}, {Tag: "ATTF", Length: 1}, {Tag: "ATTF", Length: 2}}
+	if f, ok := d.Field("ATTF"); !ok || f != &d.Fields[1] {
+		t.Error("Expected the first ATTF field, got ", f)
+	}
+	if f, ok := d.Field("NATF"); ok {
+		t.Error("Expected no NATF field, got ", f)
+	}
+	if f := d.FieldsByTag("ATTF"); len(f) != 2 || f[1] != &d.Fields[2] {
+		t.Error("Expected both ATTF fields, got ", f)
+	}
+}
+
+func TestStrictRecordLength(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	// Claim the first data record is one byte longer.
+	bad := append([]byte{}, data...)
+	copy(bad[1814:], "00145")
+	for _, strict := range []bool{false, true} {
+		r := bytes.NewReader(bad)
+		l := LeadRecord{Strict: strict}
+		if err = l.Read(r); err != nil {
+			t.Fatal("Error reading the lead record: ", err)
+		}
+		d := DataRecord{Lead: &l, Strict: strict}
+		if err = d.Read(r); (err != nil) != strict {
+			t.Error("Strict ", strict, " got ", err)
+		}
+	}
+}
+
+func TestBlankRecordLength(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	blank := append([]byte{}, data...)
+	copy(blank, "     ")
+	copy(blank[1814:], "     ")
+	copy(blank[1814+144:], "     ")
+	f, err := NewReader(bytes.NewReader(blank))
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if f.Lead.Header.RecordLength != 1814 {
+		t.Error("Expected a 1814 byte lead record, got ", f.Lead.Header.RecordLength)
+	}
+	f.Strict = true
+	d, err := f.Next()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if d.Header.RecordLength != 144 {
+		t.Error("Expected a 144 byte data record, got ", d.Header.RecordLength)
+	}
+	if err = f.Skip(1); err != nil {
+		t.Error("Unexpected error skipping the last record: ", err)
+	}
+	fa, err := NewFileAt(bytes.NewReader(blank), int64(len(blank)))
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if o, err := fa.Offsets(); err != nil || !reflect.DeepEqual(o, []int64{1814, 1814 + 144}) {
+		t.Error("Expected offsets 1814 and 1958, got ", o, err)
+	}
+}
+
+func TestHeaderBaseAddress(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	for _, base := range []string{"00000", "00010", "00024", "ABCDE", "     "} {
+		bad := append([]byte{}, data...)
+		copy(bad[12:], base)
+		var h Header
+		if err = h.Read(bytes.NewReader(bad)); err == nil || !strings.Contains(err.Error(), "base address") {
+			t.Error("Expected a base address error for ", base, ", got ", err)
+		}
+	}
+	bad := append([]byte{}, data...)
+	copy(bad[12:], "00025")
+	var h Header
+	if err = h.Read(bytes.NewReader(bad)); err != nil || len(h.Entries) != 0 {
+		t.Error("Expected an empty directory, got ", h.Entries, err)
+	}
+}
+
+func TestHeaderNotISO8211(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	for _, c := range []struct {
+		offset int
+		value  string
+	}{{6, "X"}, {20, "A"}, {23, "0"}} {
+		bad := append([]byte{}, data...)
+		copy(bad[c.offset:], c.value)
+		var h Header
+		if err = h.Read(bytes.NewReader(bad)); !errors.Is(err, ErrNotISO8211) {
+			t.Error("Expected ", c.value, " at ", c.offset, " to be rejected, got ", err)
+		}
+	}
+	// The interchange level, in line code extension indicator, version
+	// and application indicator are only checked when strict.
+	for _, c := range []struct {
+		offset int
+		value  string
+	}{{5, "4"}, {7, "X"}, {8, "9"}, {9, "\x00"}} {
+		bad := append([]byte{}, data...)
+		copy(bad[c.offset:], c.value)
+		for _, strict := range []bool{false, true} {
+			l := LeadRecord{Strict: strict}
+			if err = l.Read(bytes.NewReader(bad)); (err != nil) != strict {
+				t.Error("Strict ", strict, " with ", c.value, " at ", c.offset, " got ", err)
+			}
+		}
+	}
+}
+
+func TestHeaderDirectoryNumbers(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	record := data[1814:1958]
+	if string(record[24:32]) != "00010300" {
+		t.Fatalf("Unexpected directory entry %q", record[24:32])
+	}
+	for _, c := range []struct {
+		offset int
+		value  string
+		err    string
+	}{
+		{28, "x", `record at offset 0: directory entry 0, field 0001: length "x3" is not a number`},
+		{30, " ", `record at offset 0: directory entry 0, field 0001: position " 0" is not a number`},
+		{28, "-", `record at offset 0: directory entry 0, field 0001: length "-3" is not a number`},
+	} {
+		bad := append([]byte{}, record...)
+		copy(bad[c.offset:], c.value)
+		var h Header
+		if err = h.Read(bytes.NewReader(bad)); err == nil || err.Error() != c.err {
+			t.Error("Expected ", c.err, ", got ", err)
+		}
+	}
+}
+
+func TestWrongRecordType(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	var l LeadRecord
+	if err = l.Read(bytes.NewReader(data[1814:])); !errors.Is(err, ErrNotLeadRecord) || errors.Is(err, ErrNotISO8211) {
+		t.Error("Expected ErrNotLeadRecord, got ", err)
+	}
+	d := DataRecord{Lead: &l}
+	if err = d.Read(bytes.NewReader(data)); !errors.Is(err, ErrNotDataRecord) {
+		t.Error("Expected ErrNotDataRecord, got ", err)
+	}
+	if err = l.Read(strings.NewReader("not an ISO 8211 file at all")); !errors.Is(err, ErrNotISO8211) {
+		t.Error("Expected ErrNotISO8211, got ", err)
+	}
+}
+
+func TestHeaderCodeExtension(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	r := bytes.NewReader(data)
+	l := LeadRecord{Strict: true}
+	if err = l.Read(r); err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	if c := l.Header.CodeExtension(); c != InLineExtension || c.String() != "in line" {
+		t.Error("Expected in line, got ", c)
+	}
+	d := DataRecord{Lead: &l, Strict: true}
+	if err = d.Read(r); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if c := d.Header.CodeExtension(); c != NoCodeExtension {
+		t.Error("Expected none, got ", c)
+	}
+	// Data records must leave both indicators blank when strict.
+	for _, offset := range []int{7, 9} {
+		bad := append([]byte{}, data...)
+		bad[1814+offset] = 'E'
+		for _, strict := range []bool{false, true} {
+			d := DataRecord{Lead: &l, Strict: strict}
+			if err = d.Read(bytes.NewReader(bad[1814:])); (err != nil) != strict {
+				t.Error("Strict ", strict, " with E at ", offset, " got ", err)
+			}
+		}
+	}
+}
+
+func TestNonDefaultSizes(t *testing.T) {
+	// Two character tags and the six byte field controls of ISO 8211:1985.
+	lead := LeadRecord{Header: Header{InterchangeLevel: '2', FieldControlLength: 6}}
+	lead.FieldTypes = map[string]FieldType{
+		"01": {Tag: "01", DataStructure: '0', DataType: '1', AuxiliaryControls: []byte("00"), PrintableFt: ';', PrintableUt: '&',
+			Name: []byte("Record identifier"), ArrayDescriptor: []byte{}, FormatControls: []byte("(I(3))")},
+		"AB": {Tag: "AB", DataStructure: '1', DataType: '6', AuxiliaryControls: []byte("00"), PrintableFt: ';', PrintableUt: '&',
+			Name: []byte("Test"), ArrayDescriptor: []byte("NAME!NUMB"), FormatControls: []byte("(A,I(2))")},
+	}
+	var buf bytes.Buffer
+	if err := lead.Write(&buf); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("\x1e1600;&Test\x1f")) {
+		t.Errorf("Expected six byte field controls, got %q", buf.Bytes())
+	}
+	data := DataRecord{Fields: []Field{
+		{Tag: "01", FieldType: lead.FieldTypes["01"], SubFields: []interface{}{1}},
+		{Tag: "AB", FieldType: lead.FieldTypes["AB"], SubFields: []interface{}{"x", 12}},
+	}}
+	if err := data.Write(&buf); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	r := bytes.NewReader(buf.Bytes())
+	l := LeadRecord{Strict: true}
+	if err := l.Read(r); err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	ab := l.FieldTypes["AB"]
+	if l.Header.TagSize != 2 || string(ab.Name) != "Test" || string(ab.FormatControls) != "(A,I(2))" || ab.PrintableUt != '&' {
+		t.Error("Unexpected lead record ", l)
+	}
+	d := DataRecord{Lead: &l, Strict: true}
+	if err := d.Read(r); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if e := []interface{}{"x", 12}; !reflect.DeepEqual(d.Fields[1].SubFields, e) {
+		t.Error("Expected ", e, ", got ", d.Fields[1].SubFields)
+	}
+}
+
+func TestCustomTerminators(t *testing.T) {
+	term := Terminators{Field: '~', Unit: '|'}
+	lead := LeadRecord{Header: Header{InterchangeLevel: '2'}, Terminators: term}
+	lead.FieldTypes = map[string]FieldType{
+		"TEST": {Tag: "TEST", DataStructure: '1', DataType: '6', Name: []byte("Test"),
+			ArrayDescriptor: []byte("NAME!NUMB!COMT"), FormatControls: []byte("(A,I(2),A)"), Terminators: term},
+	}
+	var buf bytes.Buffer
+	if err := lead.Write(&buf); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	data := DataRecord{Lead: &lead, Fields: []Field{{Tag: "TEST", FieldType: lead.FieldTypes["TEST"], SubFields: []interface{}{"x", 12, "y"}}}}
+	if err := data.Write(&buf); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("Test|NAME!NUMB!COMT|(A,I(2),A)~")) || !bytes.HasSuffix(buf.Bytes(), []byte("x|12y|~")) {
+		t.Errorf("Expected the custom terminators, got %q", buf.Bytes())
+	}
+	if bytes.ContainsAny(buf.Bytes(), "\x1e\x1f") {
+		t.Errorf("Expected only the custom terminators, got %q", buf.Bytes())
+	}
+	r := bytes.NewReader(buf.Bytes())
+	l := LeadRecord{Terminators: term}
+	if err := l.Read(r); err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	if ft := l.FieldTypes["TEST"]; string(ft.FormatControls) != "(A,I(2),A)" {
+		t.Errorf("Unexpected format controls %q", ft.FormatControls)
+	}
+	d := DataRecord{Lead: &l}
+	if err := d.Read(r); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if e := []interface{}{"x", 12, "y"}; !reflect.DeepEqual(d.Fields[0].SubFields, e) {
+		t.Error("Expected ", e, ", got ", d.Fields[0].SubFields)
+	}
+	f, err := NewReaderTerminators(bytes.NewReader(buf.Bytes()), term)
+	if err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	next, err := f.Next()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if e := []interface{}{"x", 12, "y"}; !reflect.DeepEqual(next.Fields[0].SubFields, e) {
+		t.Error("Expected ", e, ", got ", next.Fields[0].SubFields)
+	}
+	report, err := ConformanceTerminators(bytes.NewReader(buf.Bytes()), term)
+	if err != nil || !report.OK() {
+		t.Error("Expected a conformant file, got ", report, err)
+	}
+}
+
+func TestHeaderValidate(t *testing.T) {
+	h := Header{BaseAddress: 30, RecordLength: 45, Entries: []DirEntry{
+		{[]byte("0001"), 5, 0},
+		{[]byte("FRID"), 10, 5},
+	}}
+	if err := h.Validate(); err != nil {
+		t.Error("Unexpected error: ", err)
+	}
+	for _, c := range []struct {
+		entry    DirEntry
+		expected string
+	}{
+		{DirEntry{[]byte("FRID"), 10, 4}, "overlaps"},
+		{DirEntry{[]byte("FRID"), 9, 6}, "gap"},
+		{DirEntry{[]byte("FRID"), 0, 5}, "length 0"},
+		{DirEntry{[]byte("FRID"), 11, 5}, "record length"},
+	} {
+		h.Entries[1] = c.entry
+		if err := h.Validate(); err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Error("Expected an error with ", c.expected, ", got ", err)
+		}
+	}
+}
+
+func TestUnresolvedField(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	for _, strict := range []bool{false, true} {
+		r := bytes.NewReader(data)
+		var l LeadRecord
+		if err = l.Read(r); err != nil {
+			t.Fatal("Error reading the lead record: ", err)
+		}
+		delete(l.FieldTypes, "DSSI")
+		d := DataRecord{Lead: &l, Strict: strict}
+		err = d.Read(r)
+		if strict {
+			if err == nil || !strings.Contains(err.Error(), "DSSI") {
+				t.Error("Expected a DSSI error, got ", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		if !d.Fields[1].Resolved() || d.Fields[2].Resolved() || d.Fields[2].SubFields != nil {
+			t.Error("Expected only DSSI to be unresolved")
+		}
+	}
+}
+
+func TestRawFields(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	var d DataRecord
+	if err = d.Read(bytes.NewReader(data[1814:])); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	raw := d.RawFields()
+	if len(raw) != 3 || raw[0].Tag != "0001" || raw[1].Tag != "DSID" {
+		t.Fatal("Expected the 0001, DSID and DSSI fields, got ", raw)
+	}
+	if !bytes.Equal(raw[0].Data, []byte{1, 0}) || len(raw[0].Units) != 1 {
+		t.Error("Expected the 0001 record number, got ", raw[0])
+	}
+	if u := raw[1].Units; len(u) != 6 || string(u[1]) != "36" {
+		t.Errorf("Expected 6 DSID units with the edition 36 second, got %q", u)
+	}
+}
+
+func TestDataRecordReset(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	var l LeadRecord
+	r := bytes.NewReader(data)
+	if err = l.Read(r); err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	e := readTestRecords(t)
+	d := DataRecord{Lead: &l}
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			d.Reset()
+		}
+		if err = d.Read(r); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		if d.String() != e[i].String() || d.Header.RecordLength != e[i].Header.RecordLength {
+			t.Error("Expected ", e[i], ", got ", d)
+		}
+	}
+	d.Reset()
+	if len(d.Fields) != 0 || cap(d.Fields) == 0 || d.Lead != &l || d.Header.RecordLength != 0 {
+		t.Error("Expected Reset to keep only the Lead and the capacity, got ", d)
+	}
+	if err = d.Read(r); err != io.EOF {
+		t.Error("Expected io.EOF, got ", err)
+	}
+}
+
+func TestDecodeTags(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	want := readTestRecords(t)[1]
+	for _, keepRaw := range []bool{false, true} {
+		f, err := NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal("Error reading the lead record: ", err)
+		}
+		f.KeepRaw = keepRaw
+		f.DecodeTags = map[string]bool{"FRID": true, "FOID": true}
+		if err = f.Skip(1); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		d, err := f.Next()
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		for i, field := range d.Fields {
+			wanted := f.DecodeTags[field.Tag]
+			if wanted && !reflect.DeepEqual(field.SubFields, want.Fields[i].SubFields) {
+				t.Error("Expected ", field.Tag, " to be decoded, got ", field.SubFields)
+			}
+			if !wanted && field.SubFields != nil {
+				t.Error("Expected ", field.Tag, " to be skipped, got ", field.SubFields)
+			}
+			if (field.Raw != nil) != keepRaw {
+				t.Error("KeepRaw ", keepRaw, " with ", field.Tag, " got Raw ", field.Raw)
+			}
+		}
+		// The fields read past are written from Raw, or not at all.
+		var buf bytes.Buffer
+		if err = d.Write(&buf); (err == nil) != keepRaw {
+			t.Error("KeepRaw ", keepRaw, " got Write error ", err)
+		}
+		if keepRaw && !bytes.Equal(buf.Bytes(), data[len(data)-int(d.Header.RecordLength):]) {
+			t.Errorf("Expected the record as read, got %q", buf.Bytes())
+		}
+		if keepRaw {
+			if err = d.Decode(); err != nil || d.String() != want.String() {
+				t.Error("Expected Decode to decode the skipped fields, got ", d, err)
+			}
+		}
+	}
+	// The SubFields Reset keeps are nil, not empty, when not decoded.
+	l := want.Lead
+	d := DataRecord{Lead: l}
+	if err = d.Read(bytes.NewReader(data[1958:])); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	d.Reset()
+	d.DecodeTags = map[string]bool{}
+	if err = d.Read(bytes.NewReader(data[1958:])); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if d.Fields[3].SubFields != nil {
+		t.Error("Expected nil SubFields after Reset, got ", d.Fields[3].SubFields)
+	}
+}
+
+func TestKeepRaw(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	for _, keep := range []bool{false, true} {
+		f, err := NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		f.KeepRaw = keep
+		d, err := f.Next()
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		dsid, _ := d.Field("DSID")
+		if !keep {
+			if dsid.Raw != nil {
+				t.Error("Expected no raw data without KeepRaw, got ", dsid.Raw)
+			}
+			continue
+		}
+		start := dsid.Offset
+		if e := data[start : start+int64(dsid.Length)]; !bytes.Equal(dsid.Raw, e) {
+			t.Errorf("Expected %q, got %q", e, dsid.Raw)
+		}
+		// An unresolved field is written back from its raw data.
+		d.Fields[1].FieldType = FieldType{}
+		var buf bytes.Buffer
+		if err = d.Write(&buf); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		if e := data[1814 : 1814+144]; !bytes.Equal(buf.Bytes(), e) {
+			t.Errorf("Expected %q, got %q", e, buf.Bytes())
+		}
+	}
+}
+
+func TestEachSubField(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	var e []string
+	for _, lazy := range []bool{false, true} {
+		f, err := NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		f.Lazy = lazy
+		f.Skip(1)
+		d, err := f.Next()
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		if lazy && d.Fields[3].SubFields != nil {
+			t.Error("Expected a lazy ATTF to be undecoded, got ", d.Fields[3].SubFields)
+		}
+		var got []string
+		err = d.EachSubField(func(field, sub string, v interface{}) error {
+			got = append(got, fmt.Sprint(field, ".", sub, "=", v))
+			return nil
+		})
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		if !lazy {
+			e = got
+			if len(e) != 17 || e[16] != "ATTF.ATVL=US,US,reprt,5thCGD,LNM 46/12" {
+				t.Error("Unexpected subfields ", e)
+			}
+		} else if !reflect.DeepEqual(got, e) {
+			t.Error("Expected ", e, ", got ", got)
+		}
+		stop := errors.New("stop")
+		n := 0
+		err = d.EachSubField(func(field, sub string, v interface{}) error {
+			n++
+			if field == "FOID" {
+				return stop
+			}
+			return nil
+		})
+		if err != stop || n != 9 {
+			t.Error("Expected to stop at the first FOID subfield, got ", err, n)
+		}
+	}
+}
+
+func TestLeadOnlyFile(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	// The first 1814 bytes are the lead record.
+	f := bytes.NewReader(data[:1814])
+	var l LeadRecord
+	if err = l.Read(f); err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	d := DataRecord{Lead: &l}
+	if err = d.Read(f); err != io.EOF {
+		t.Error("Expected io.EOF, got ", err)
 	}
 }
 
@@ -88,3 +1619,31 @@ func Example() {
 	// Rec: 1
 	// Rec: 2
 }
+
+func TestFieldTypeCodes(t *testing.T) {
+	f, err := os.Open("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	defer f.Close()
+	var l LeadRecord
+	if err = l.Read(f); err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	codes := []struct {
+		tag string
+		s   DataStructure
+		t   DataType
+	}{
+		{"0000", Elementary, CharacterString},
+		{"0001", Elementary, BitString},
+		{"FRID", Linear, MixedDataTypes},
+		{"SG2D", Array, MixedDataTypes},
+	}
+	for _, c := range codes {
+		ft := l.FieldTypes[c.tag]
+		if ft.Structure() != c.s || ft.Type() != c.t {
+			t.Error(c.tag, " expected ", c.s, "/", c.t, ", got ", ft.Structure(), "/", ft.Type())
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// MarshalJSON encodes the record as an object of its fields keyed by tag,
+// in record order. A tag that occurs more than once has an array of its
+// fields.
+func (data DataRecord) MarshalJSON() ([]byte, error) {
+	var tags []string
+	fields := make(map[string][]json.RawMessage)
+	for i := range data.Fields {
+		f := &data.Fields[i]
+		b, err := f.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := fields[f.Tag]; !ok {
+			tags = append(tags, f.Tag)
+		}
+		fields[f.Tag] = append(fields[f.Tag], b)
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, tag := range tags {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(tag)
+		buf.Write(key)
+		buf.WriteByte(':')
+		if len(fields[tag]) == 1 {
+			buf.Write(fields[tag][0])
+		} else {
+			b, _ := json.Marshal(fields[tag])
+			buf.Write(b)
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// MarshalJSON encodes the SubFields as an object keyed by subfield tag,
+// in format order, or an array of such objects when the field repeats
+// (see FieldType.Repeats).
+// A field with a single untagged subfield, like 0001, is just its value,
+// and one without a format is an array of its values. Binary subfields
+// are base64 strings.
+func (field Field) MarshalJSON() ([]byte, error) {
+	types := field.FieldType.Format()
+	switch {
+	case len(types) == 0:
+		return json.Marshal(field.SubFields)
+	case len(types) == 1 && len(types[0].Tag) == 0 && len(field.SubFields) == 1:
+		return json.Marshal(field.SubFields[0])
+	}
+	var rows []json.RawMessage
+	for i := 0; i < len(field.SubFields); i += len(types) {
+		end := i + len(types)
+		if end > len(field.SubFields) {
+			end = len(field.SubFields)
+		}
+		row, err := marshalRow(types, field.SubFields[i:end])
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	if !field.FieldType.Repeats() && len(rows) == 1 {
+		return rows[0], nil
+	}
+	return json.Marshal(rows)
+}
+
+func marshalRow(types []SubFieldType, values []interface{}) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(string(types[i].Tag))
+		buf.Write(key)
+		buf.WriteByte(':')
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(b)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,31 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	var out bytes.Buffer
+	for _, d := range readTestRecords(t) {
+		b, err := json.Marshal(d)
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		out.Write(b)
+		out.WriteByte('\n')
+	}
+	golden, err := ioutil.ReadFile("testdata/US5MD12M.json")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if !bytes.Equal(out.Bytes(), golden) {
+		t.Errorf("Expected\n%s\ngot\n%s", golden, out.Bytes())
+	}
+}
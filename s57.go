@@ -0,0 +1,611 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RecordKey identifies an S-57 record by its record name (RCNM) and
+// record identification number (RCID).
+type RecordKey struct {
+	RCNM uint8
+	RCID uint32
+}
+
+// ChangeKind says how a record differs between two cells.
+type ChangeKind int
+
+const (
+	RecordAdded ChangeKind = iota + 1
+	RecordRemoved
+	RecordModified
+)
+
+// AttributeChange is a single attribute (ATTL) whose value (ATVL) differs
+// between two versions of a record. An empty Before or After means the
+// attribute was absent.
+type AttributeChange struct {
+	Label  uint16
+	Before string
+	After  string
+}
+
+// CellChange describes a record that was added, removed or modified.
+// Before is nil for added records and After is nil for removed ones.
+type CellChange struct {
+	Kind       ChangeKind
+	Key        RecordKey
+	Before     *DataRecord
+	After      *DataRecord
+	Attributes []AttributeChange
+}
+
+// DiffCells compares the records of a cell before and after an update and
+// reports every record that was added, removed or modified, ordered by
+// RecordKey. Records are matched by their RCNM/RCID.
+func DiffCells(before, after []*DataRecord) ([]CellChange, error) {
+	b, err := indexRecords(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := indexRecords(after)
+	if err != nil {
+		return nil, err
+	}
+	var changes []CellChange
+	for key, old := range b {
+		rec, ok := a[key]
+		if !ok {
+			changes = append(changes, CellChange{Kind: RecordRemoved, Key: key, Before: old})
+			continue
+		}
+		if !sameFields(old, rec) {
+			changes = append(changes, CellChange{Kind: RecordModified, Key: key, Before: old, After: rec,
+				Attributes: diffAttributes(attributes(old), attributes(rec))})
+		}
+	}
+	for key, rec := range a {
+		if _, ok := b[key]; !ok {
+			changes = append(changes, CellChange{Kind: RecordAdded, Key: key, After: rec})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key.less(changes[j].Key)
+	})
+	return changes, nil
+}
+
+func (k RecordKey) less(o RecordKey) bool {
+	if k.RCNM != o.RCNM {
+		return k.RCNM < o.RCNM
+	}
+	return k.RCID < o.RCID
+}
+
+func indexRecords(records []*DataRecord) (map[RecordKey]*DataRecord, error) {
+	index := make(map[RecordKey]*DataRecord, len(records))
+	for _, d := range records {
+		key, ok := recordKey(d)
+		if !ok {
+			return nil, errors.New("record has no RCNM/RCID")
+		}
+		if _, dup := index[key]; dup {
+			return nil, fmt.Errorf("duplicate record RCNM %d RCID %d", key.RCNM, key.RCID)
+		}
+		index[key] = d
+	}
+	return index, nil
+}
+
+// recordKey finds the RCNM and RCID subfields of the record identifier
+// field (DSID, FRID, VRID...).
+func recordKey(d *DataRecord) (RecordKey, bool) {
+	for i := range d.Fields {
+		f := &d.Fields[i]
+		v, _ := f.SubField("RCNM")
+		rcnm, ok1 := v.(uint8)
+		v, _ = f.SubField("RCID")
+		rcid, ok2 := v.(uint32)
+		if ok1 && ok2 {
+			return RecordKey{rcnm, rcid}, true
+		}
+	}
+	return RecordKey{}, false
+}
+
+// sameFields compares the fields of two records, ignoring the ISO 8211
+// record identifier (0001) which is only a sequence number.
+func sameFields(a, b *DataRecord) bool {
+	fa, fb := dataFields(a), dataFields(b)
+	if len(fa) != len(fb) {
+		return false
+	}
+	for i := range fa {
+		if fa[i].Tag != fb[i].Tag || !reflect.DeepEqual(fa[i].SubFields, fb[i].SubFields) {
+			return false
+		}
+	}
+	return true
+}
+
+func dataFields(d *DataRecord) []Field {
+	var fields []Field
+	for _, f := range d.Fields {
+		if f.Tag != "0001" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// Attributes collects the attribute label (ATTL) and value (ATVL) pairs
+// of the record's ATTF, NATF and ATTV fields. Every occurrence of a label
+// is kept, in field order, so repeated labels are not lost. Use ListValues
+// to split the value of a list type attribute such as COLOUR.
+func (data *DataRecord) Attributes() map[uint16][]string {
+	attrs := make(map[uint16][]string)
+	data.eachAttribute(func(label uint16, value string) {
+		attrs[label] = append(attrs[label], value)
+	})
+	return attrs
+}
+
+// DeleteValue is the ATVL of an update record attribute that is to be
+// deleted. It is the ASCII delete character.
+const DeleteValue = "\x7f"
+
+// AttributeUpdates returns the attribute instructions of an update record.
+// A label that is absent is unchanged, a nil value deletes the attribute
+// (its ATVL was DeleteValue) and any other value, including an empty
+// one, replaces it. Repeated labels keep the last value.
+func (data *DataRecord) AttributeUpdates() map[uint16]*string {
+	attrs := make(map[uint16]*string)
+	data.eachAttribute(func(label uint16, value string) {
+		if value == DeleteValue {
+			attrs[label] = nil
+		} else {
+			attrs[label] = &value
+		}
+	})
+	return attrs
+}
+
+// ListValues splits the value of a list type attribute into its
+// comma separated values. Whether an attribute is a list is defined by
+// the S-57 attribute catalogue, e.g. COLOUR "1,3" is but SORIND is not.
+func ListValues(atvl string) []string {
+	return strings.Split(atvl, ",")
+}
+
+// AttributeType is the type of an attribute in the S-57 attribute
+// catalogue, which ATVL values do not carry: their format is always A.
+type AttributeType byte
+
+// The S-57 attribute types.
+const (
+	TextAttribute        AttributeType = 0   // Not in the catalogue, kept as text.
+	EnumeratedAttribute  AttributeType = 'E' // One enumerated value, an int.
+	ListAttribute        AttributeType = 'L' // Comma separated enumerated values, an []int.
+	FloatAttribute       AttributeType = 'F' // A float64.
+	IntegerAttribute     AttributeType = 'I' // An int.
+	CodedStringAttribute AttributeType = 'A' // A string.
+	FreeTextAttribute    AttributeType = 'S' // A string.
+)
+
+// ParseATVL converts an attribute value to the Go type of its attribute
+// type, see the AttributeType constants. An empty value, an attribute
+// whose value is unknown, and DeleteValue are nil.
+func ParseATVL(atvl string, t AttributeType) (interface{}, error) {
+	if atvl == "" || atvl == DeleteValue {
+		return nil, nil
+	}
+	switch t {
+	case EnumeratedAttribute, IntegerAttribute:
+		return strconv.Atoi(strings.TrimSpace(atvl))
+	case ListAttribute:
+		values := ListValues(atvl)
+		list := make([]int, len(values))
+		for i, v := range values {
+			n, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil {
+				return nil, err
+			}
+			list[i] = n
+		}
+		return list, nil
+	case FloatAttribute:
+		return strconv.ParseFloat(strings.TrimSpace(atvl), 64)
+	}
+	return atvl, nil
+}
+
+// TypedAttributes is Attributes with each value converted by ParseATVL
+// to the type the types function gives for its label, e.g. from the S-57
+// attribute catalogue. A nil types keeps every value as text. The
+// error names the first value that cannot be converted, which is kept
+// as text; the others are still converted.
+func (data *DataRecord) TypedAttributes(types func(label uint16) AttributeType) (map[uint16][]interface{}, error) {
+	attrs := make(map[uint16][]interface{})
+	var first error
+	data.eachAttribute(func(label uint16, value string) {
+		t := TextAttribute
+		if types != nil {
+			t = types(label)
+		}
+		v, err := ParseATVL(value, t)
+		if err != nil {
+			if first == nil {
+				first = fmt.Errorf("attribute %d: value %q: %v", label, value, err)
+			}
+			v = value
+		}
+		attrs[label] = append(attrs[label], v)
+	})
+	return attrs, first
+}
+
+func (data *DataRecord) eachAttribute(fn func(label uint16, value string)) {
+	for i := range data.Fields {
+		f := &data.Fields[i]
+		if f.Tag == "ATTF" || f.Tag == "NATF" || f.Tag == "ATTV" {
+			f.eachAttribute(fn)
+		}
+	}
+}
+
+// Attributes maps the attribute labels (ATTL) of an ATTF, NATF or ATTV
+// field to their values (ATVL). A label repeated in the field keeps its
+// last value; DataRecord.Attributes keeps them all.
+func (field *Field) Attributes() map[uint16]string {
+	attrs := make(map[uint16]string)
+	field.eachAttribute(func(label uint16, value string) {
+		attrs[label] = value
+	})
+	return attrs
+}
+
+// eachAttribute calls fn with each ATTL/ATVL pair of the repeating field.
+func (field *Field) eachAttribute(fn func(label uint16, value string)) {
+	labels, values := field.SubFieldValues("ATTL"), field.SubFieldValues("ATVL")
+	for i := 0; i < len(labels) && i < len(values); i++ {
+		label, ok := labels[i].(uint16)
+		value, _ := values[i].(string)
+		if ok {
+			fn(label, value)
+		}
+	}
+}
+
+// attributes maps each attribute label to its last value.
+func attributes(d *DataRecord) map[uint16]string {
+	attrs := make(map[uint16]string)
+	d.eachAttribute(func(label uint16, value string) {
+		attrs[label] = value
+	})
+	return attrs
+}
+
+func diffAttributes(before, after map[uint16]string) []AttributeChange {
+	var changes []AttributeChange
+	for label, v := range before {
+		if w := after[label]; v != w {
+			changes = append(changes, AttributeChange{label, v, w})
+		}
+	}
+	for label, w := range after {
+		if _, ok := before[label]; !ok {
+			changes = append(changes, AttributeChange{label, "", w})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Label < changes[j].Label
+	})
+	return changes
+}
+
+// Coord is a position. For S-57 data X is the longitude and Y the
+// latitude in WGS84 degrees until it is transformed to another CRS.
+type Coord struct {
+	X, Y float64
+}
+
+// ScaleCoordinate converts a raw coordinate subfield, XCOO, YCOO or
+// VE3D, to its real value by dividing it by the multiplication factor of
+// the DSPM field: COMF for positions, SOMF for sounding depths.
+func ScaleCoordinate(raw int32, comf uint32) float64 {
+	return float64(raw) / float64(comf)
+}
+
+// ScaledCoord converts the raw XCOO and YCOO subfields of a coordinate
+// field to degrees using the coordinate multiplication factor (COMF) of
+// the DSPM field.
+func ScaledCoord(xcoo, ycoo int32, comf uint32) Coord {
+	return Coord{ScaleCoordinate(xcoo, comf), ScaleCoordinate(ycoo, comf)}
+}
+
+// Coords returns the positions of the SG2D and SG3D fields of a vector
+// record in degrees, scaled by the COMF of the DSPM field. depths has the
+// VE3D sounding depth of each SG3D position scaled by SOMF, and is empty
+// for SG2D fields. Fields without a format are skipped.
+func (data *DataRecord) Coords(comf, somf uint32) (coords []Coord, depths []float64) {
+	for i := range data.Fields {
+		f := &data.Fields[i]
+		if f.Tag != "SG2D" && f.Tag != "SG3D" {
+			continue
+		}
+		types := f.FieldType.Format()
+		if len(types) == 0 {
+			continue
+		}
+		for r := 0; r+len(types) <= len(f.SubFields); r += len(types) {
+			var x, y, z int32
+			for j, t := range types {
+				v, _ := f.SubFields[r+j].(int32)
+				switch string(t.Tag) {
+				case "XCOO":
+					x = v
+				case "YCOO":
+					y = v
+				case "VE3D":
+					z = v
+				}
+			}
+			coords = append(coords, ScaledCoord(x, y, comf))
+			if f.Tag == "SG3D" {
+				depths = append(depths, ScaleCoordinate(z, somf))
+			}
+		}
+	}
+	return coords, depths
+}
+
+// Transform returns the coordinate reprojected by fn, which is passed
+// the longitude and latitude and returns the projected x and y. It lets
+// callers plug in an external projection library.
+func (c Coord) Transform(fn func(lon, lat float64) (x, y float64)) Coord {
+	x, y := fn(c.X, c.Y)
+	return Coord{x, y}
+}
+
+// TransformCoords applies Transform to each of coords.
+func TransformCoords(coords []Coord, fn func(lon, lat float64) (x, y float64)) []Coord {
+	out := make([]Coord, len(coords))
+	for i, c := range coords {
+		out[i] = c.Transform(fn)
+	}
+	return out
+}
+
+// ProfileKind is the broad kind of an ISO 8211 file.
+type ProfileKind int
+
+const (
+	GenericISO8211 ProfileKind = iota // Not recognisably S-57.
+	S57Cell                           // An S-57 base cell (.000).
+	S57Update                         // An S-57 update file (.001, .002...).
+	S57Catalog                        // An S-57 exchange set catalog.
+)
+
+// Profile classifies a file. Edition and UpdateNumber are the STED and
+// UPDN subfields of an S-57 cell's DSID, e.g. 3.1 and "1".
+type Profile struct {
+	Kind         ProfileKind
+	Level        byte // The interchange level of the lead record.
+	Edition      float64
+	UpdateNumber string
+}
+
+// Detect reads the lead record and, for S-57 cells, the DSID record to
+// classify a file. If r is an io.Seeker it is returned to its starting
+// offset, otherwise the records read are consumed.
+func Detect(r io.Reader) (Profile, error) {
+	if s, ok := r.(io.Seeker); ok {
+		offset, err := s.Seek(0, io.SeekCurrent)
+		if err == nil {
+			defer s.Seek(offset, io.SeekStart)
+		}
+	}
+	var p Profile
+	var lead LeadRecord
+	if err := lead.Read(r); err != nil {
+		return p, err
+	}
+	p.Level = lead.Header.InterchangeLevel
+	if _, ok := lead.FieldTypes["CATD"]; ok {
+		p.Kind = S57Catalog
+		return p, nil
+	}
+	if _, ok := lead.FieldTypes["DSID"]; !ok {
+		return p, nil
+	}
+	d := DataRecord{Lead: &lead}
+	if err := d.Read(r); err != nil {
+		return p, err
+	}
+	dsid, ok := d.Field("DSID")
+	if !ok {
+		return p, nil
+	}
+	p.Kind = S57Cell
+	if v, _ := dsid.SubField("EXPP"); v == uint8(2) {
+		p.Kind = S57Update
+	}
+	v, _ := dsid.SubField("STED")
+	p.Edition, _ = v.(float64)
+	v, _ = dsid.SubField("UPDN")
+	p.UpdateNumber, _ = v.(string)
+	return p, nil
+}
+
+// DSID is the data set identification field of an S-57 data set.
+type DSID struct {
+	RCNM uint8   `iso8211:"RCNM"` // Record name, 10.
+	RCID uint32  `iso8211:"RCID"` // Record identification number.
+	EXPP uint8   `iso8211:"EXPP"` // Exchange purpose, 1 new, 2 revision.
+	INTU uint8   `iso8211:"INTU"` // Intended usage, the navigational purpose.
+	DSNM string  `iso8211:"DSNM"` // Data set name, the file name.
+	EDTN string  `iso8211:"EDTN"` // Edition number.
+	UPDN string  `iso8211:"UPDN"` // Update number.
+	UADT string  `iso8211:"UADT"` // Update application date.
+	ISDT string  `iso8211:"ISDT"` // Issue date.
+	STED float64 `iso8211:"STED"` // Edition number of S-57.
+	PRSP uint8   `iso8211:"PRSP"` // Product specification.
+	PSDN string  `iso8211:"PSDN"` // Product specification description.
+	PRED string  `iso8211:"PRED"` // Product specification edition number.
+	PROF uint8   `iso8211:"PROF"` // Application profile identification.
+	AGEN uint16  `iso8211:"AGEN"` // Producing agency.
+	COMT string  `iso8211:"COMT"` // Comment.
+}
+
+// DSPM is the data set parameter field of an S-57 data set.
+type DSPM struct {
+	RCNM uint8  `iso8211:"RCNM"` // Record name, 20.
+	RCID uint32 `iso8211:"RCID"` // Record identification number.
+	HDAT uint8  `iso8211:"HDAT"` // Horizontal geodetic datum.
+	VDAT uint8  `iso8211:"VDAT"` // Vertical datum.
+	SDAT uint8  `iso8211:"SDAT"` // Sounding datum.
+	CSCL uint32 `iso8211:"CSCL"` // Compilation scale of data.
+	DUNI uint8  `iso8211:"DUNI"` // Units of depth measurement.
+	HUNI uint8  `iso8211:"HUNI"` // Units of height measurement.
+	PUNI uint8  `iso8211:"PUNI"` // Units of positional accuracy.
+	COUN uint8  `iso8211:"COUN"` // Coordinate units.
+	COMF uint32 `iso8211:"COMF"` // Coordinate multiplication factor.
+	SOMF uint32 `iso8211:"SOMF"` // 3-D (sounding) multiplication factor.
+	COMT string `iso8211:"COMT"` // Comment.
+}
+
+// FRID is the feature record identifier field of an S-57 feature record.
+type FRID struct {
+	RCNM uint8  `iso8211:"RCNM"` // Record name, 100.
+	RCID uint32 `iso8211:"RCID"` // Record identification number.
+	PRIM uint8  `iso8211:"PRIM"` // Object geometric primitive.
+	GRUP uint8  `iso8211:"GRUP"` // Group.
+	OBJL uint16 `iso8211:"OBJL"` // Object label, the object class code.
+	RVER uint16 `iso8211:"RVER"` // Record version.
+	RUIN uint8  `iso8211:"RUIN"` // Record update instruction.
+}
+
+// FOID is the feature object identifier field of an S-57 feature record.
+// It identifies a feature across cells and updates and, being comparable,
+// may be used as a map key.
+type FOID struct {
+	AGEN uint16 `iso8211:"AGEN"` // Producing agency.
+	FIDN uint32 `iso8211:"FIDN"` // Feature identification number.
+	FIDS uint16 `iso8211:"FIDS"` // Feature identification subdivision.
+}
+
+// String returns the canonical key of the feature, AGEN:FIDN:FIDS.
+func (f FOID) String() string {
+	return fmt.Sprintf("%d:%d:%d", f.AGEN, f.FIDN, f.FIDS)
+}
+
+// LongName returns the feature's long name (LNAM), the form FFPT fields
+// use to refer to it: AGEN, FIDN and FIDS as 8 little endian bytes.
+func (f FOID) LongName() []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint16(b, f.AGEN)
+	binary.LittleEndian.PutUint32(b[2:], f.FIDN)
+	binary.LittleEndian.PutUint16(b[6:], f.FIDS)
+	return b
+}
+
+// VRID is the vector record identifier field of an S-57 vector record.
+type VRID struct {
+	RCNM uint8  `iso8211:"RCNM"` // Record name, 110 to 130.
+	RCID uint32 `iso8211:"RCID"` // Record identification number.
+	RVER uint16 `iso8211:"RVER"` // Record version.
+	RUIN uint8  `iso8211:"RUIN"` // Record update instruction.
+}
+
+// ParseDSID returns the DSID field of the data set general information
+// record.
+func ParseDSID(d *DataRecord) (*DSID, error) {
+	var v DSID
+	return &v, parseField(d, "DSID", &v)
+}
+
+// ParseDSPM returns the DSPM field of the data set geographic reference
+// record.
+func ParseDSPM(d *DataRecord) (*DSPM, error) {
+	var v DSPM
+	return &v, parseField(d, "DSPM", &v)
+}
+
+// ParseFRID returns the FRID field of a feature record.
+func ParseFRID(d *DataRecord) (*FRID, error) {
+	var v FRID
+	return &v, parseField(d, "FRID", &v)
+}
+
+// ParseFOID returns the FOID field of a feature record.
+func ParseFOID(d *DataRecord) (*FOID, error) {
+	var v FOID
+	return &v, parseField(d, "FOID", &v)
+}
+
+// ParseVRID returns the VRID field of a vector record.
+func ParseVRID(d *DataRecord) (*VRID, error) {
+	var v VRID
+	return &v, parseField(d, "VRID", &v)
+}
+
+func parseField(d *DataRecord, tag string, dst interface{}) error {
+	f, ok := d.Field(tag)
+	if !ok {
+		return fmt.Errorf("record has no %s field", tag)
+	}
+	return f.DecodeInto(dst)
+}
+
+// CatalogEntry is the CATD field of an S-57 exchange set catalog
+// (CATALOG.031), describing one file of the exchange set. The bounds are
+// zero for files without coverage, such as text files.
+type CatalogEntry struct {
+	RCNM string  `iso8211:"RCNM"` // Record name, CD.
+	RCID int     `iso8211:"RCID"` // Record identification number.
+	FILE string  `iso8211:"FILE"` // File name, with its path in the exchange set.
+	LFIL string  `iso8211:"LFIL"` // File long name.
+	VOLM string  `iso8211:"VOLM"` // Volume.
+	IMPL string  `iso8211:"IMPL"` // Implementation, e.g. BIN for cells.
+	SLAT float64 `iso8211:"SLAT"` // Southernmost latitude.
+	WLON float64 `iso8211:"WLON"` // Westernmost longitude.
+	NLAT float64 `iso8211:"NLAT"` // Northernmost latitude.
+	ELON float64 `iso8211:"ELON"` // Easternmost longitude.
+	CRCS string  `iso8211:"CRCS"` // CRC of the file, in hex.
+	COMT string  `iso8211:"COMT"` // Comment.
+}
+
+// ReadCatalog reads the CATD entries of an S-57 exchange set catalog.
+func ReadCatalog(r io.Reader) ([]CatalogEntry, error) {
+	f, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	var entries []CatalogEntry
+	for {
+		d, err := f.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+		for _, catd := range d.FieldsByTag("CATD") {
+			var e CatalogEntry
+			if err := catd.DecodeInto(&e); err != nil {
+				return entries, err
+			}
+			entries = append(entries, e)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func readTestRecords(t *testing.T) []*DataRecord {
+	f, err := os.Open("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	defer f.Close()
+	var l LeadRecord
+	if err := l.Read(f); err != nil {
+		t.Fatal("Error reading the lead record: ", err)
+	}
+	var records []*DataRecord
+	for {
+		d := &DataRecord{Lead: &l}
+		if d.Read(f) != nil {
+			break
+		}
+		records = append(records, d)
+	}
+	return records
+}
+
+func TestDiffCells(t *testing.T) {
+	before := readTestRecords(t)
+	after := readTestRecords(t)
+	// Drop the DSID record and change an attribute value of the feature.
+	after = after[1:]
+	attf := &after[0].Fields[3]
+	attf.SubFields = append([]interface{}{}, attf.SubFields...)
+	attf.SubFields[3] = "20130101"
+	changes, err := DiffCells(before, after)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if len(changes) != 2 {
+		t.Fatal("Expected 2 changes, got ", changes)
+	}
+	if c := changes[0]; c.Kind != RecordRemoved || c.Key != (RecordKey{10, 1}) {
+		t.Error("Expected DSID removal, got ", c)
+	}
+	c := changes[1]
+	if c.Kind != RecordModified || c.Key != (RecordKey{100, 1357}) {
+		t.Error("Expected FRID modification, got ", c)
+	}
+	e := AttributeChange{147, "20121113", "20130101"}
+	if len(c.Attributes) != 1 || c.Attributes[0] != e {
+		t.Error("Expected ", e, ", got ", c.Attributes)
+	}
+	changes, err = DiffCells(before, before)
+	if err != nil || len(changes) != 0 {
+		t.Error("Expected no changes, got ", changes, err)
+	}
+}
+
+func TestCoordTransform(t *testing.T) {
+	c := ScaledCoord(-763000000, 389500000, 10000000)
+	if c != (Coord{-76.3, 38.95}) {
+		t.Error("Expected -76.3,38.95, got ", c)
+	}
+	swap := func(lon, lat float64) (float64, float64) { return lat, lon }
+	v := TransformCoords([]Coord{c}, swap)
+	if len(v) != 1 || v[0] != (Coord{38.95, -76.3}) {
+		t.Error("Expected 38.95,-76.3, got ", v)
+	}
+}
+
+func TestDataRecordCoords(t *testing.T) {
+	sg3d := FieldType{Tag: "SG3D", ArrayDescriptor: []byte("*YCOO!XCOO!VE3D"), FormatControls: []byte("(3b24)")}
+	d := DataRecord{Fields: []Field{{
+		Tag:       "SG3D",
+		FieldType: sg3d,
+		SubFields: []interface{}{int32(389500000), int32(-763000000), int32(125), int32(389600000), int32(-763100000), int32(30)},
+	}}}
+	coords, depths := d.Coords(10000000, 10)
+	e := []Coord{{-76.3, 38.95}, {-76.31, 38.96}}
+	if !reflect.DeepEqual(coords, e) {
+		t.Error("Expected ", e, ", got ", coords)
+	}
+	if !reflect.DeepEqual(depths, []float64{12.5, 3}) {
+		t.Error("Expected [12.5 3], got ", depths)
+	}
+	d.Fields[0].FieldType = FieldType{Tag: "SG3D"}
+	if coords, depths = d.Coords(10000000, 10); coords != nil || depths != nil {
+		t.Error("Expected no positions without a format, got ", coords, depths)
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	d := readTestRecords(t)[1]
+	attf := &d.Fields[3]
+	attf.SubFields = append(attf.SubFields, uint16(75), "1,3", uint16(147), "20130101")
+	a := d.Attributes()
+	e := map[uint16][]string{
+		147: {"20121113", "20130101"},
+		148: {"US,US,reprt,5thCGD,LNM 46/12"},
+		75:  {"1,3"},
+		178: {"5"},
+	}
+	if !reflect.DeepEqual(a, e) {
+		t.Error("Expected ", e, ", got ", a)
+	}
+	if v := ListValues(a[75][0]); !reflect.DeepEqual(v, []string{"1", "3"}) {
+		t.Error("Expected [1 3], got ", v)
+	}
+}
+
+func TestTypedAttributes(t *testing.T) {
+	d := readTestRecords(t)[1]
+	attf := &d.Fields[3]
+	attf.SubFields = append(attf.SubFields, uint16(75), "1,3", uint16(87), "2.5", uint16(88), "")
+	types := map[uint16]AttributeType{75: ListAttribute, 87: FloatAttribute, 88: FloatAttribute,
+		147: CodedStringAttribute, 148: FreeTextAttribute, 178: EnumeratedAttribute}
+	a, err := d.TypedAttributes(func(label uint16) AttributeType { return types[label] })
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	e := map[uint16][]interface{}{
+		75:  {[]int{1, 3}},
+		87:  {2.5},
+		88:  {nil},
+		147: {"20121113"},
+		148: {"US,US,reprt,5thCGD,LNM 46/12"},
+		178: {5},
+	}
+	if !reflect.DeepEqual(a, e) {
+		t.Error("Expected ", e, ", got ", a)
+	}
+	if a, err = d.TypedAttributes(nil); err != nil || a[178][0] != "5" || a[87][0] != "2.5" {
+		t.Error("Expected text values without types, got ", a, err)
+	}
+	types[148] = IntegerAttribute
+	a, err = d.TypedAttributes(func(label uint16) AttributeType { return types[label] })
+	if err == nil || a[148][0] != "US,US,reprt,5thCGD,LNM 46/12" || a[178][0] != 5 {
+		t.Error("Expected an error for attribute 148, got ", a, err)
+	}
+}
+
+func TestFieldAttributes(t *testing.T) {
+	d := readTestRecords(t)[1]
+	attf, _ := d.Field("ATTF")
+	e := map[uint16]string{
+		178: "5",
+		147: "20121113",
+		148: "US,US,reprt,5thCGD,LNM 46/12",
+	}
+	if a := attf.Attributes(); !reflect.DeepEqual(a, e) {
+		t.Error("Expected ", e, ", got ", a)
+	}
+}
+
+func TestAttributeUpdates(t *testing.T) {
+	d := readTestRecords(t)[1]
+	attf := &d.Fields[3]
+	attf.SubFields = []interface{}{uint16(147), DeleteValue, uint16(148), ""}
+	a := d.AttributeUpdates()
+	if v, ok := a[147]; !ok || v != nil {
+		t.Error("Expected 147 to be deleted, got ", v)
+	}
+	if v, ok := a[148]; !ok || v == nil || *v != "" {
+		t.Error("Expected 148 to be empty, got ", v)
+	}
+	if _, ok := a[178]; ok || len(a) != 2 {
+		t.Error("Expected 178 to be unchanged, got ", a)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	f, err := os.Open("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	defer f.Close()
+	p, err := Detect(f)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	e := Profile{S57Update, '3', 3.1, "1"}
+	if p != e {
+		t.Error("Expected ", e, ", got ", p)
+	}
+	var l LeadRecord
+	if err = l.Read(f); err != nil {
+		t.Error("Detect did not rewind the file: ", err)
+	}
+}
+
+func TestParseS57Fields(t *testing.T) {
+	records := readTestRecords(t)
+	dsid, err := ParseDSID(records[0])
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if dsid.DSNM != "US5MD12M.001" || dsid.EXPP != 2 || dsid.UPDN != "1" || dsid.STED != 3.1 || dsid.AGEN != 550 {
+		t.Error("Unexpected DSID ", dsid)
+	}
+	frid, err := ParseFRID(records[1])
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	e := FRID{RCNM: 100, RCID: 1357, PRIM: 1, GRUP: 2, OBJL: 75, RVER: 2, RUIN: UpdateModify}
+	if *frid != e {
+		t.Error("Expected ", e, ", got ", *frid)
+	}
+	if _, err = ParseDSPM(records[0]); err == nil {
+		t.Error("Expected an error for the missing DSPM field")
+	}
+	if _, err = ParseVRID(records[1]); err == nil {
+		t.Error("Expected an error for the missing VRID field")
+	}
+	foid, err := ParseFOID(records[1])
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if *foid != (FOID{550, 8734295, 50}) || foid.String() != "550:8734295:50" {
+		t.Error("Unexpected FOID ", *foid)
+	}
+	if l := foid.LongName(); !bytes.Equal(l, []byte{0x26, 0x02, 0x57, 0x46, 0x85, 0x00, 0x32, 0x00}) {
+		t.Errorf("Unexpected long name %x", l)
+	}
+}
+
+func TestReadCatalog(t *testing.T) {
+	catd := FieldType{Tag: "CATD", DataStructure: '1', DataType: '6', Name: []byte("Catalogue Directory field"),
+		ArrayDescriptor: []byte("RCNM!RCID!FILE!LFIL!VOLM!IMPL!SLAT!WLON!NLAT!ELON!CRCS!COMT"),
+		FormatControls:  []byte("(A(2),I(10),3A,A(3),4R,2A)")}
+	lead := LeadRecord{Header: Header{InterchangeLevel: '3'}, FieldTypes: map[string]FieldType{"CATD": catd}}
+	var buf bytes.Buffer
+	if err := lead.Write(&buf); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	entries := [][]interface{}{
+		{"CD", 1, "US5MD12M\\US5MD12M.000", "", "V01X01", "BIN", 38.9, -76.5, 39.1, -76.3, "1A2B3C4D", ""},
+		{"CD", 2, "README.TXT", "", "V01X01", "TXT", 0.0, 0.0, 0.0, 0.0, "", ""},
+	}
+	for _, e := range entries {
+		d := DataRecord{Fields: []Field{{Tag: "CATD", FieldType: catd, SubFields: e}}}
+		if err := d.Write(&buf); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+	}
+	catalog, err := ReadCatalog(&buf)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	e := CatalogEntry{RCNM: "CD", RCID: 1, FILE: "US5MD12M\\US5MD12M.000", VOLM: "V01X01", IMPL: "BIN",
+		SLAT: 38.9, WLON: -76.5, NLAT: 39.1, ELON: -76.3, CRCS: "1A2B3C4D"}
+	if len(catalog) != 2 || catalog[0] != e || catalog[1].FILE != "README.TXT" {
+		t.Error("Unexpected catalog ", catalog)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// DecodeInto decodes buffer like Decode and stores the SubFields in the
+// struct pointed to by dst. Struct fields are matched to subfields by
+// their iso8211 struct tag, e.g.
+//
+//	type FOID struct {
+//		AGEN uint16 `iso8211:"AGEN"`
+//		FIDN uint32 `iso8211:"FIDN"`
+//		FIDS uint16 `iso8211:"FIDS"`
+//	}
+//
+// A value must be assignable to its struct field, or both must be
+// numbers, which are converted. A blank number, a nil value, leaves its
+// struct field zero. A slice struct field of a repeating
+// field collects every value of its subfield. A tag missing from the
+// format is an error unless the struct tag has the optional option,
+// `iso8211:"COMT,optional"`.
+func (dir FieldType) DecodeInto(buffer []byte, dst interface{}) error {
+	values, err := dir.DecodeErr(buffer)
+	if err != nil {
+		return err
+	}
+	return decodeInto(dir.Tag, dir.Format(), values, dst)
+}
+
+// DecodeInto is FieldType.DecodeInto for the SubFields already decoded.
+func (field *Field) DecodeInto(dst interface{}) error {
+	return decodeInto(field.Tag, field.FieldType.Format(), field.SubFields, dst)
+}
+
+// decodeInto stores the decoded values of a field in dst.
+func decodeInto(tag string, types []SubFieldType, values []interface{}, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("field %s: cannot decode into %T, it is not a pointer to a struct", tag, dst)
+	}
+	v = v.Elem()
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		name, opts := parseStructTag(sf.Tag.Get("iso8211"))
+		if name == "" {
+			continue
+		}
+		var found []interface{}
+		for j, value := range values {
+			if len(types) > 0 && string(types[j%len(types)].Tag) == name {
+				found = append(found, value)
+			}
+		}
+		if len(found) == 0 {
+			if opts == "optional" {
+				continue
+			}
+			return fmt.Errorf("field %s: no subfield %s for %s", tag, name, sf.Name)
+		}
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Slice && (found[0] == nil || !reflect.TypeOf(found[0]).AssignableTo(fv.Type())) {
+			s := reflect.MakeSlice(fv.Type(), len(found), len(found))
+			for j, value := range found {
+				if err := setValue(s.Index(j), value); err != nil {
+					return fmt.Errorf("field %s: subfield %s: %v", tag, name, err)
+				}
+			}
+			fv.Set(s)
+			continue
+		}
+		if err := setValue(fv, found[0]); err != nil {
+			return fmt.Errorf("field %s: subfield %s: %v", tag, name, err)
+		}
+	}
+	return nil
+}
+
+func parseStructTag(tag string) (name, opts string) {
+	if i := strings.IndexByte(tag, ','); i >= 0 {
+		return tag[:i], tag[i+1:]
+	}
+	return tag, ""
+}
+
+// setValue assigns value to dst, converting between numeric types. A nil
+// value is the zero value.
+func setValue(dst reflect.Value, value interface{}) error {
+	v := reflect.ValueOf(value)
+	switch {
+	case !v.IsValid():
+		dst.Set(reflect.Zero(dst.Type()))
+	case v.Type().AssignableTo(dst.Type()):
+		dst.Set(v)
+	case isNumber(v.Kind()) && isNumber(dst.Kind()):
+		dst.Set(v.Convert(dst.Type()))
+	default:
+		return fmt.Errorf("cannot assign %v to %v", v.Type(), dst.Type())
+	}
+	return nil
+}
+
+func isNumber(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeInto(t *testing.T) {
+	var f FieldType
+	f.Tag = "FOID"
+	f.FormatControls = []byte("(b12,b14,b12)")
+	f.ArrayDescriptor = []byte("AGEN!FIDN!FIDS")
+	data := []byte{0x26, 0x02, 0x57, 0x46, 0x85, 0x00, 0x32, 0x00}
+	var foid struct {
+		AGEN uint16 `iso8211:"AGEN"`
+		FIDN int    `iso8211:"FIDN"`
+		FIDS uint16 `iso8211:"FIDS"`
+		COMT string `iso8211:"COMT,optional"`
+		Note string
+	}
+	if err := f.DecodeInto(data, &foid); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if foid.AGEN != 550 || foid.FIDN != 8734295 || foid.FIDS != 50 || foid.COMT != "" {
+		t.Error("Unexpected FOID ", foid)
+	}
+	var missing struct {
+		COMT string `iso8211:"COMT"`
+	}
+	if err := f.DecodeInto(data, &missing); err == nil {
+		t.Error("Expected an error for the missing COMT subfield")
+	}
+	var mismatch struct {
+		AGEN string `iso8211:"AGEN"`
+	}
+	if err := f.DecodeInto(data, &mismatch); err == nil {
+		t.Error("Expected an error assigning AGEN to a string")
+	}
+	if err := f.DecodeInto(data, foid); err == nil {
+		t.Error("Expected an error decoding into a struct value")
+	}
+}
+
+func TestDecodeIntoRepeating(t *testing.T) {
+	var f FieldType
+	f.Tag = "SG2D"
+	f.FormatControls = []byte("(2b24)")
+	f.ArrayDescriptor = []byte("*YCOO!XCOO")
+	data := []byte{1, 0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 0, 4, 0, 0, 0}
+	var sg2d struct {
+		YCOO []int32   `iso8211:"YCOO"`
+		XCOO []float64 `iso8211:"XCOO"`
+	}
+	if err := f.DecodeInto(data, &sg2d); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if !reflect.DeepEqual(sg2d.YCOO, []int32{1, 3}) || !reflect.DeepEqual(sg2d.XCOO, []float64{2, 4}) {
+		t.Error("Unexpected SG2D ", sg2d)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+// Node is a Field of a record with the fields nested within it, see
+// DataRecord.Tree.
+type Node struct {
+	Field    *Field
+	Children []*Node
+}
+
+// Tree nests the Fields of the record by the TagPairs of its lead
+// record, e.g. an S-57 feature record is a 0001 node with a FRID child,
+// which has the FOID, ATTF and FFPT children. A field is the child of
+// the nearest field before it that the TagPairs make its parent, and a
+// root otherwise, so without TagPairs every field is a root. The nodes
+// point into the Fields of the record.
+func (data *DataRecord) Tree() []*Node {
+	parents := make(map[TagPair]bool)
+	if data.Lead != nil {
+		for _, p := range data.Lead.TagPairs {
+			parents[p] = true
+		}
+	}
+	var roots, open []*Node
+	for i := range data.Fields {
+		n := &Node{Field: &data.Fields[i]}
+		for len(open) > 0 && !parents[TagPair{open[len(open)-1].Field.Tag, n.Field.Tag}] {
+			open = open[:len(open)-1]
+		}
+		if len(open) == 0 {
+			roots = append(roots, n)
+		} else {
+			p := open[len(open)-1]
+			p.Children = append(p.Children, n)
+		}
+		open = append(open, n)
+	}
+	return roots
+}
@@ -0,0 +1,41 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"strings"
+	"testing"
+)
+
+// treeString writes the tags of the nodes, with the children of each in
+// parentheses.
+func treeString(nodes []*Node) string {
+	var tags []string
+	for _, n := range nodes {
+		s := n.Field.Tag
+		if len(n.Children) > 0 {
+			s += "(" + treeString(n.Children) + ")"
+		}
+		tags = append(tags, s)
+	}
+	return strings.Join(tags, " ")
+}
+
+func TestDataRecordTree(t *testing.T) {
+	records := readTestRecords(t)
+	for i, e := range []string{"0001(DSID(DSSI))", "0001(FRID(FOID ATTF))"} {
+		if s := treeString(records[i].Tree()); s != e {
+			t.Error("Expected ", e, ", got ", s)
+		}
+	}
+	d := records[1]
+	if n := d.Tree()[0].Children[0]; n.Field != &d.Fields[1] {
+		t.Error("Expected the node to point into the record, got ", n.Field)
+	}
+	d.Lead = &LeadRecord{}
+	if s := treeString(d.Tree()); s != "0001 FRID FOID ATTF" {
+		t.Error("Expected only roots without tag pairs, got ", s)
+	}
+}
@@ -0,0 +1,595 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// The record update instructions (RUIN) of S-57 update files, also used
+// by the update instructions of the pointer and coordinate control fields.
+const (
+	UpdateInsert = 1
+	UpdateDelete = 2
+	UpdateModify = 3
+)
+
+// updateControls maps the S-57 control fields to the fields they update.
+var updateControls = map[string][]string{
+	"FFPC": {"FFPT"},
+	"FSPC": {"FSPT"},
+	"VRPC": {"VRPT"},
+	"SGCC": {"SG2D", "SG3D"},
+}
+
+// ApplyUpdate reads an S-57 update file (.001, .002...) and applies its
+// records to the records of a base cell, or of the previous update, keyed
+// by RCNM/RCID. Records are inserted, deleted or modified according to
+// their RUIN, and the version (RVER) of each modified record must follow
+// the one it updates. Modifications replace or delete (see DeleteValue)
+// attributes and edit pointers and coordinates through the control fields.
+// The DSID of the base takes the update number and issue date of the
+// update. base is not changed; the merged records are returned.
+func ApplyUpdate(base []DataRecord, update io.Reader) ([]DataRecord, error) {
+	f, err := NewReader(update)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]DataRecord, len(base))
+	index := make(map[RecordKey]int, len(base))
+	for i := range base {
+		records[i] = *base[i].Clone()
+		if key, ok := recordKey(&records[i]); ok {
+			index[key] = i
+		}
+	}
+	deleted := make(map[int]bool)
+	for {
+		u, err := f.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if dsid, ok := u.Field("DSID"); ok {
+			if i, ok := index[RecordKey{10, 1}]; ok {
+				updateDSID(&records[i], dsid)
+			}
+			continue
+		}
+		key, ok := recordKey(u)
+		if !ok {
+			return nil, fmt.Errorf("update record has no RCNM/RCID")
+		}
+		id := identifierField(u)
+		if id == nil {
+			return nil, fmt.Errorf("record %v: update record has no RUIN", key)
+		}
+		ruin, _ := id.SubField("RUIN")
+		i, exists := index[key]
+		if exists && deleted[i] {
+			exists = false
+		}
+		switch ruin {
+		case uint8(UpdateInsert):
+			if exists {
+				return nil, fmt.Errorf("record %v: inserted record already exists", key)
+			}
+			index[key] = len(records)
+			records = append(records, *u.Clone())
+		case uint8(UpdateDelete), uint8(UpdateModify):
+			if !exists {
+				return nil, fmt.Errorf("record %v: updated record does not exist", key)
+			}
+			if err := checkVersion(key, &records[i], id); err != nil {
+				return nil, err
+			}
+			if ruin == uint8(UpdateDelete) {
+				deleted[i] = true
+			} else if err := modifyRecord(&records[i], u); err != nil {
+				return nil, fmt.Errorf("record %v: %v", key, err)
+			}
+		default:
+			return nil, fmt.Errorf("record %v: unknown update instruction %v", key, ruin)
+		}
+	}
+	merged := records[:0]
+	for i, d := range records {
+		if !deleted[i] {
+			merged = append(merged, d)
+		}
+	}
+	return merged, nil
+}
+
+// identifierField returns the record identifier field, FRID or VRID, the
+// field with the RUIN subfield.
+func identifierField(d *DataRecord) *Field {
+	for i := range d.Fields {
+		if _, ok := d.Fields[i].SubField("RUIN"); ok {
+			return &d.Fields[i]
+		}
+	}
+	return nil
+}
+
+func checkVersion(key RecordKey, rec *DataRecord, id *Field) error {
+	var old, next interface{}
+	if f := identifierField(rec); f != nil {
+		old, _ = f.SubField("RVER")
+	}
+	next, _ = id.SubField("RVER")
+	o, ok1 := old.(uint16)
+	n, ok2 := next.(uint16)
+	if !ok1 || !ok2 || n != o+1 {
+		return fmt.Errorf("record %v: update version %v does not follow %v", key, next, old)
+	}
+	return nil
+}
+
+// setSubField sets the first subfield tagged name.
+func setSubField(f *Field, name string, value interface{}) {
+	types := f.FieldType.Format()
+	if len(types) == 0 {
+		return
+	}
+	for i := range f.SubFields {
+		if string(types[i%len(types)].Tag) == name {
+			f.SubFields[i] = value
+			return
+		}
+	}
+}
+
+func updateDSID(rec *DataRecord, dsid *Field) {
+	f, ok := rec.Field("DSID")
+	if !ok {
+		return
+	}
+	for _, name := range []string{"UPDN", "ISDT"} {
+		if v, ok := dsid.SubField(name); ok {
+			setSubField(f, name, v)
+		}
+	}
+}
+
+// modifyRecord applies the fields of a modify update record to rec.
+func modifyRecord(rec *DataRecord, u *DataRecord) error {
+	id := identifierField(u)
+	rver, _ := id.SubField("RVER")
+	setSubField(identifierField(rec), "RVER", rver)
+	for _, tag := range []string{"ATTF", "NATF", "ATTV"} {
+		if f, ok := u.Field(tag); ok {
+			updateAttributes(rec, f)
+		}
+	}
+	// Each control field applies to the next of its target fields, and a
+	// record may have more than one pair for a target.
+	for i := range u.Fields {
+		targets := updateControls[u.Fields[i].Tag]
+	next:
+		for j := i + 1; j < len(u.Fields); j++ {
+			for _, target := range targets {
+				if u.Fields[j].Tag == target {
+					if err := updateRows(rec, &u.Fields[i], &u.Fields[j]); err != nil {
+						return err
+					}
+					break next
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// updateAttributes replaces, adds or deletes the ATTL/ATVL pairs of the
+// attribute field of rec with the pairs of the update field u.
+func updateAttributes(rec *DataRecord, u *Field) {
+	f, ok := rec.Field(u.Tag)
+	if !ok {
+		rec.Fields = append(rec.Fields, Field{Tag: u.Tag, FieldType: u.FieldType})
+		f = &rec.Fields[len(rec.Fields)-1]
+	}
+	for i := 0; i+1 < len(u.SubFields); i += 2 {
+		label, value := u.SubFields[i], u.SubFields[i+1]
+		found := -1
+		for j := 0; j+1 < len(f.SubFields); j += 2 {
+			if f.SubFields[j] == label {
+				found = j
+				break
+			}
+		}
+		switch {
+		case value == DeleteValue && found >= 0:
+			f.SubFields = append(f.SubFields[:found], f.SubFields[found+2:]...)
+		case value == DeleteValue:
+		case found >= 0:
+			f.SubFields[found+1] = value
+		default:
+			f.SubFields = append(f.SubFields, label, value)
+		}
+	}
+	if len(f.SubFields) == 0 {
+		removeField(rec, f.Tag)
+	}
+}
+
+// updateRows inserts, deletes or modifies the rows, pointers or
+// coordinates, of a repeating field of rec as instructed by a control
+// field such as FFPC, whose subfields are the update instruction, the
+// index of the first row and the number of rows.
+func updateRows(rec *DataRecord, control, u *Field) error {
+	if len(control.SubFields) < 3 {
+		return fmt.Errorf("%s: expected 3 subfields", control.Tag)
+	}
+	var args [3]int
+	for i, v := range control.SubFields[:3] {
+		switch n := v.(type) {
+		case uint8:
+			args[i] = int(n)
+		case uint16:
+			args[i] = int(n)
+		default:
+			return fmt.Errorf("%s: subfield %d is %T", control.Tag, i, v)
+		}
+	}
+	instruction, start, count := args[0], args[1]-1, args[2]
+	width := len(u.FieldType.Format())
+	if width == 0 || start < 0 {
+		return fmt.Errorf("%s: cannot update %s", control.Tag, u.Tag)
+	}
+	f, ok := rec.Field(u.Tag)
+	if !ok {
+		if instruction != UpdateInsert {
+			return fmt.Errorf("%s: no %s field to update", control.Tag, u.Tag)
+		}
+		rec.Fields = append(rec.Fields, Field{Tag: u.Tag, FieldType: u.FieldType})
+		f = &rec.Fields[len(rec.Fields)-1]
+	}
+	rows := f.SubFields
+	lo, hi := start*width, (start+count)*width
+	if lo > len(rows) || (instruction != UpdateInsert && hi > len(rows)) {
+		return fmt.Errorf("%s: rows %d to %d are beyond the %d rows of %s",
+			control.Tag, start+1, start+count, len(rows)/width, u.Tag)
+	}
+	var updated []interface{}
+	switch instruction {
+	case UpdateInsert:
+		updated = append(updated, rows[:lo]...)
+		updated = append(updated, u.SubFields...)
+		updated = append(updated, rows[lo:]...)
+	case UpdateDelete:
+		updated = append(updated, rows[:lo]...)
+		updated = append(updated, rows[hi:]...)
+	case UpdateModify:
+		updated = append(updated, rows[:lo]...)
+		updated = append(updated, u.SubFields...)
+		updated = append(updated, rows[hi:]...)
+	default:
+		return fmt.Errorf("%s: unknown update instruction %d", control.Tag, instruction)
+	}
+	f.SubFields = updated
+	if len(updated) == 0 {
+		removeField(rec, f.Tag)
+	}
+	return nil
+}
+
+func removeField(rec *DataRecord, tag string) {
+	for i := range rec.Fields {
+		if rec.Fields[i].Tag == tag {
+			rec.Fields = append(rec.Fields[:i], rec.Fields[i+1:]...)
+			return
+		}
+	}
+}
+
+// BuildUpdate is the inverse of ApplyUpdate: it compares the records of
+// a base cell, or of the previous update, with their modified versions,
+// keyed by RCNM/RCID, and returns the records of an S-57 update file
+// that makes base into modified. The DSID record of modified comes first,
+// with EXPP set to 2 for an update, then a record for each record that
+// was inserted, deleted or modified, ordered by RecordKey. Insertions are
+// version 1; deletions and modifications take the next version (RVER) of
+// the base record. A modification replaces or deletes (see DeleteValue)
+// attributes and updates the pointers and coordinates that changed,
+// through the control fields of the lead record of modified: a modify of
+// the rows that changed, then an insert or delete of the rows added or
+// dropped. Other changes, such as to the FOID or OBJL of a feature,
+// cannot be expressed by an update and are an error. The 0001 record
+// identifiers count from 1. Write the lead record of modified and then
+// the records to make the update file.
+func BuildUpdate(base, modified []DataRecord) ([]DataRecord, error) {
+	b, err := indexRecords(recordPointers(base))
+	if err != nil {
+		return nil, err
+	}
+	m, err := indexRecords(recordPointers(modified))
+	if err != nil {
+		return nil, err
+	}
+	dsid := RecordKey{10, 1}
+	var keys []RecordKey
+	for key := range b {
+		if _, ok := m[key]; !ok && key != dsid {
+			keys = append(keys, key)
+		}
+	}
+	for key := range m {
+		if key != dsid {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].less(keys[j])
+	})
+	var update []DataRecord
+	if d, ok := m[dsid]; ok {
+		u := d.Clone()
+		if f, ok := u.Field("DSID"); ok {
+			setSubField(f, "EXPP", uint8(2))
+		}
+		update = append(update, *u)
+	}
+	for _, key := range keys {
+		old, mod := b[key], m[key]
+		var u *DataRecord
+		switch {
+		case old == nil:
+			u = mod.Clone()
+			id := identifierField(u)
+			if id == nil {
+				return nil, fmt.Errorf("record %v: no record identifier field", key)
+			}
+			setSubField(id, "RVER", uint16(1))
+			setSubField(id, "RUIN", uint8(UpdateInsert))
+		case mod == nil:
+			if u, err = updateRecord(old, UpdateDelete); err != nil {
+				return nil, fmt.Errorf("record %v: %v", key, err)
+			}
+		default:
+			if u, err = modifyUpdate(old, mod); err != nil {
+				return nil, fmt.Errorf("record %v: %v", key, err)
+			}
+		}
+		if u != nil {
+			update = append(update, *u)
+		}
+	}
+	for i := range update {
+		if f, ok := update[i].Field("0001"); ok && len(f.SubFields) == 1 {
+			if _, ok := f.SubFields[0].(uint16); ok {
+				f.SubFields[0] = uint16(i + 1)
+			}
+		}
+	}
+	return update, nil
+}
+
+func recordPointers(records []DataRecord) []*DataRecord {
+	pointers := make([]*DataRecord, len(records))
+	for i := range records {
+		pointers[i] = &records[i]
+	}
+	return pointers
+}
+
+// updateRecord returns an update record for rec with the instruction:
+// its 0001 and record identifier fields, with the next version.
+func updateRecord(rec *DataRecord, instruction uint8) (*DataRecord, error) {
+	id := identifierField(rec)
+	if id == nil {
+		return nil, fmt.Errorf("no record identifier field")
+	}
+	v, _ := id.SubField("RVER")
+	rver, ok := v.(uint16)
+	if !ok {
+		return nil, fmt.Errorf("version %v is not a uint16", v)
+	}
+	u := &DataRecord{Lead: rec.Lead}
+	if f, ok := rec.Field("0001"); ok {
+		u.Fields = append(u.Fields, f.Clone())
+	}
+	u.Fields = append(u.Fields, id.Clone())
+	id = &u.Fields[len(u.Fields)-1]
+	setSubField(id, "RVER", rver+1)
+	setSubField(id, "RUIN", instruction)
+	return u, nil
+}
+
+// modifyUpdate returns the modify update record that makes old into mod,
+// or nil if they do not differ.
+func modifyUpdate(old, mod *DataRecord) (*DataRecord, error) {
+	u, err := updateRecord(old, UpdateModify)
+	if err != nil {
+		return nil, err
+	}
+	changed := false
+	handled := map[string]bool{"0001": true}
+	for _, tag := range []string{"ATTF", "NATF", "ATTV"} {
+		handled[tag] = true
+		if f := attributeUpdate(old, mod, tag); f != nil {
+			u.Fields = append(u.Fields, *f)
+			changed = true
+		}
+	}
+	var controls []string
+	for control := range updateControls {
+		controls = append(controls, control)
+	}
+	sort.Strings(controls)
+	for _, control := range controls {
+		for _, target := range updateControls[control] {
+			handled[target] = true
+			fields, err := rowsUpdate(old, mod, control, target)
+			if err != nil {
+				return nil, err
+			}
+			u.Fields = append(u.Fields, fields...)
+			changed = changed || fields != nil
+		}
+	}
+	// Everything else must be unchanged, but the version and instruction.
+	ids := [2]*Field{identifierField(old), identifierField(mod)}
+	if ids[1] == nil {
+		return nil, fmt.Errorf("no record identifier field in the modified record")
+	}
+	handled[ids[0].Tag] = true
+	for i, id := range ids {
+		c := id.Clone()
+		setSubField(&c, "RVER", nil)
+		setSubField(&c, "RUIN", nil)
+		ids[i] = &c
+	}
+	if !reflect.DeepEqual(ids[0].SubFields, ids[1].SubFields) {
+		return nil, fmt.Errorf("field %s changed, which an update cannot express", ids[0].Tag)
+	}
+	others := [2][]Field{}
+	for i, d := range []*DataRecord{old, mod} {
+		for _, f := range d.Fields {
+			if !handled[f.Tag] {
+				others[i] = append(others[i], f)
+			}
+		}
+	}
+	if len(others[0]) != len(others[1]) {
+		return nil, fmt.Errorf("fields were added or removed, which an update cannot express")
+	}
+	for i, f := range others[0] {
+		if g := others[1][i]; f.Tag != g.Tag || !reflect.DeepEqual(f.SubFields, g.SubFields) {
+			return nil, fmt.Errorf("field %s changed, which an update cannot express", f.Tag)
+		}
+	}
+	if !changed {
+		return nil, nil
+	}
+	return u, nil
+}
+
+// attributeUpdate returns the attribute field tag of an update that makes
+// the attributes of old those of mod, or nil if they are the same.
+func attributeUpdate(old, mod *DataRecord, tag string) *Field {
+	var before, after map[uint16]string
+	var ft FieldType
+	if f, ok := old.Field(tag); ok {
+		before, ft = f.Attributes(), f.FieldType
+	}
+	if f, ok := mod.Field(tag); ok {
+		after, ft = f.Attributes(), f.FieldType
+	}
+	var labels []int
+	for label, v := range after {
+		if w, ok := before[label]; !ok || v != w {
+			labels = append(labels, int(label))
+		}
+	}
+	for label := range before {
+		if _, ok := after[label]; !ok {
+			labels = append(labels, int(label))
+		}
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	sort.Ints(labels)
+	f := &Field{Tag: tag, FieldType: ft}
+	for _, label := range labels {
+		value, ok := after[uint16(label)]
+		if !ok {
+			value = DeleteValue
+		}
+		f.SubFields = append(f.SubFields, uint16(label), value)
+	}
+	return f
+}
+
+// rowsUpdate returns the control and target field pairs of an update
+// that makes the rows of the target field of old those of mod, or nil if
+// they are the same: a modify of the rows both have, if any changed, then
+// an insert of the rows mod adds or a delete of the rows it drops.
+func rowsUpdate(old, mod *DataRecord, control, target string) ([]Field, error) {
+	before, bok := old.Field(target)
+	after, aok := mod.Field(target)
+	if (!bok && !aok) || (bok && aok && reflect.DeepEqual(before.SubFields, after.SubFields)) {
+		return nil, nil
+	}
+	if mod.Lead == nil {
+		return nil, fmt.Errorf("no lead record for the %s control field", control)
+	}
+	ft, ok := mod.Lead.FieldTypes[control]
+	if !ok {
+		return nil, fmt.Errorf("no %s control field in the lead record", control)
+	}
+	var rows [2][]interface{}
+	var tft FieldType
+	if bok {
+		rows[0], tft = before.SubFields, before.FieldType
+	}
+	if aok {
+		rows[1], tft = after.SubFields, after.FieldType
+	}
+	width := len(tft.Format())
+	if width == 0 {
+		return nil, fmt.Errorf("%s: no format to count the rows of", target)
+	}
+	shared := len(rows[0])
+	if len(rows[1]) < shared {
+		shared = len(rows[1])
+	}
+	var fields []Field
+	add := func(instruction, start, end int, values []interface{}) error {
+		c, err := controlField(control, ft, instruction, start/width+1, (end-start)/width)
+		if err != nil {
+			return err
+		}
+		fields = append(fields, c, Field{Tag: target, FieldType: tft, SubFields: values})
+		return nil
+	}
+	if shared > 0 && !reflect.DeepEqual(rows[0][:shared], rows[1][:shared]) {
+		if err := add(UpdateModify, 0, shared, rows[1][:shared]); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	switch {
+	case len(rows[1]) > shared:
+		err = add(UpdateInsert, shared, len(rows[1]), rows[1][shared:])
+	case len(rows[0]) > shared:
+		err = add(UpdateDelete, shared, len(rows[0]), nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// controlField returns the control field tag, of the FieldType ft, with
+// the update instruction, the index of the first row and the number of
+// rows.
+func controlField(tag string, ft FieldType, instruction, index, rows int) (Field, error) {
+	c := Field{Tag: tag, FieldType: ft}
+	types := ft.Format()
+	if len(types) != 3 {
+		return c, fmt.Errorf("%s: expected 3 subfields", tag)
+	}
+	for i, n := range []int{instruction, index, rows} {
+		switch {
+		case types[i].Kind == reflect.Uint8 && n <= math.MaxUint8:
+			c.SubFields = append(c.SubFields, uint8(n))
+		case types[i].Kind == reflect.Uint16 && n <= math.MaxUint16:
+			c.SubFields = append(c.SubFields, uint16(n))
+		case types[i].Kind == reflect.Uint8 || types[i].Kind == reflect.Uint16:
+			return c, fmt.Errorf("%s: subfield %s: %d is too large for %v", tag, types[i].Tag, n, types[i].Kind)
+		default:
+			return c, fmt.Errorf("%s: subfield %s is %v, not b11 or b12", tag, types[i].Tag, types[i].Kind)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,256 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testBase builds the base cell the test update applies to: version 1 of
+// its feature record, with different attributes.
+func testBase(t *testing.T) []DataRecord {
+	var base []DataRecord
+	for _, d := range readTestRecords(t) {
+		base = append(base, *d.Clone())
+	}
+	dsid, _ := base[0].Field("DSID")
+	setSubField(dsid, "UPDN", "0")
+	frid, _ := base[1].Field("FRID")
+	setSubField(frid, "RVER", uint16(1))
+	setSubField(frid, "RUIN", uint8(UpdateInsert))
+	attf, _ := base[1].Field("ATTF")
+	attf.SubFields = []interface{}{uint16(178), "4", uint16(116), "Buoy"}
+	return base
+}
+
+func TestApplyUpdate(t *testing.T) {
+	base := testBase(t)
+	f, err := os.Open("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	defer f.Close()
+	merged, err := ApplyUpdate(base, f)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if len(merged) != 2 {
+		t.Fatal("Expected 2 records, got ", len(merged))
+	}
+	dsid, _ := merged[0].Field("DSID")
+	if v, _ := dsid.SubField("UPDN"); v != "1" {
+		t.Error("Expected UPDN 1, got ", v)
+	}
+	frid, _ := merged[1].Field("FRID")
+	if v, _ := frid.SubField("RVER"); v != uint16(2) {
+		t.Error("Expected RVER 2, got ", v)
+	}
+	e := map[uint16][]string{
+		178: {"5"},
+		116: {"Buoy"},
+		147: {"20121113"},
+		148: {"US,US,reprt,5thCGD,LNM 46/12"},
+	}
+	if a := merged[1].Attributes(); !reflect.DeepEqual(a, e) {
+		t.Error("Expected ", e, ", got ", a)
+	}
+	if attf, _ := base[1].Field("ATTF"); len(attf.SubFields) != 4 {
+		t.Error("Expected the base to be unchanged, got ", attf.SubFields)
+	}
+}
+
+func TestApplyUpdateVersion(t *testing.T) {
+	base := testBase(t)
+	frid, _ := base[1].Field("FRID")
+	setSubField(frid, "RVER", uint16(2))
+	f, err := os.Open("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	defer f.Close()
+	if _, err := ApplyUpdate(base, f); err == nil {
+		t.Error("Expected an error for an out of sequence update")
+	}
+}
+
+func TestApplyUpdateNoInstruction(t *testing.T) {
+	b := NewBuilder()
+	b.Define("0001", "ISO 8211 Record Identifier", "", "(b12)")
+	b.Define("VRID", "Vector record identifier field", "RCNM!RCID", "(b11,b14)")
+	b.Record("0001", uint16(1)).Field("VRID", uint8(110), uint32(1))
+	data, err := b.Bytes()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if _, err = ApplyUpdate(nil, bytes.NewReader(data)); err == nil || !strings.Contains(err.Error(), "no RUIN") {
+		t.Error("Expected an error for the record without RUIN, got ", err)
+	}
+}
+
+func TestUpdateRows(t *testing.T) {
+	ft := FieldType{Tag: "FFPT", ArrayDescriptor: []byte("*LNAM!RIND!COMT"), FormatControls: []byte("(B(64),b11,A)")}
+	rec := DataRecord{Fields: []Field{{Tag: "FFPT", FieldType: ft, SubFields: []interface{}{"a", 1, "", "b", 2, "", "c", 3, ""}}}}
+	controls := []struct {
+		instruction, index, count uint8
+		rows                      []interface{}
+		e                         []interface{}
+	}{
+		{UpdateInsert, 2, 1, []interface{}{"x", 9, ""}, []interface{}{"a", 1, "", "x", 9, "", "b", 2, "", "c", 3, ""}},
+		{UpdateDelete, 1, 2, nil, []interface{}{"b", 2, "", "c", 3, ""}},
+		{UpdateModify, 2, 1, []interface{}{"y", 8, ""}, []interface{}{"b", 2, "", "y", 8, ""}},
+	}
+	for _, c := range controls {
+		control := &Field{Tag: "FFPC", SubFields: []interface{}{c.instruction, uint16(c.index), uint16(c.count)}}
+		u := &Field{Tag: "FFPT", FieldType: ft, SubFields: c.rows}
+		if err := updateRows(&rec, control, u); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		if !reflect.DeepEqual(rec.Fields[0].SubFields, c.e) {
+			t.Error("Expected ", c.e, ", got ", rec.Fields[0].SubFields)
+		}
+	}
+}
+
+func TestBuildUpdate(t *testing.T) {
+	base := testBase(t)
+	f, err := os.Open("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	defer f.Close()
+	modified, err := ApplyUpdate(base, f)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	// A feature deleted, one inserted, and pointers added to the other.
+	gone := *base[1].Clone()
+	setSubField(identifierField(&gone), "RCID", uint32(3000))
+	base = append(base, gone)
+	added := *modified[1].Clone()
+	setSubField(identifierField(&added), "RCID", uint32(2000))
+	setSubField(identifierField(&added), "RUIN", uint8(UpdateInsert))
+	modified = append(modified, added)
+	lead := modified[1].Lead
+	fspt := Field{Tag: "FSPT", FieldType: lead.FieldTypes["FSPT"],
+		SubFields: []interface{}{[]byte{130, 1, 0, 0, 0}, uint8(1), uint8(1), uint8(255)}}
+	modified[1].Fields = append(modified[1].Fields, fspt)
+
+	update, err := BuildUpdate(base, modified)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	var buf bytes.Buffer
+	if err = lead.Write(&buf); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	var tags []string
+	for i := range update {
+		if err = update[i].Write(&buf); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		var s []string
+		for _, f := range update[i].Fields {
+			s = append(s, f.Tag)
+		}
+		tags = append(tags, strings.Join(s, " "))
+	}
+	e := []string{"0001 DSID DSSI", "0001 FRID ATTF FSPC FSPT", "0001 FRID FOID ATTF", "0001 FRID"}
+	if !reflect.DeepEqual(tags, e) {
+		t.Error("Expected ", e, ", got ", tags)
+	}
+	if id, _ := update[3].Field("0001"); id.SubFields[0] != uint16(4) {
+		t.Error("Expected record 4, got ", id.SubFields)
+	}
+	if v, _ := identifierField(&update[2]).SubField("RVER"); v != uint16(1) {
+		t.Error("Expected the inserted record to be version 1, got ", v)
+	}
+	setSubField(identifierField(&modified[len(modified)-1]), "RVER", uint16(1))
+	merged, err := ApplyUpdate(base, &buf)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if len(merged) != len(modified) {
+		t.Fatal("Expected ", len(modified), " records, got ", len(merged))
+	}
+	for i := range merged {
+		a, b := &merged[i], &modified[i]
+		if !reflect.DeepEqual(a.Attributes(), b.Attributes()) || dataFields(a)[0].String() != dataFields(b)[0].String() {
+			t.Error("Expected ", b, ", got ", a)
+		}
+		if f, ok := a.Field("FSPT"); ok != (i == 1) || ok && !reflect.DeepEqual(f.SubFields, fspt.SubFields) {
+			t.Error("Expected the pointers of record 1, got ", f)
+		}
+	}
+
+	if update, err = BuildUpdate(modified, modified); err != nil || len(update) != 1 {
+		t.Error("Expected only the DSID, got ", update, err)
+	}
+	changed := *modified[1].Clone()
+	foid, _ := changed.Field("FOID")
+	foid.SubFields[1] = uint32(1)
+	if _, err = BuildUpdate(modified, []DataRecord{modified[0], changed, modified[2]}); err == nil {
+		t.Error("Expected an error for the changed FOID")
+	}
+}
+
+func TestBuildUpdateRows(t *testing.T) {
+	base := testBase(t)[:2]
+	lead := base[1].Lead
+	row := func(rcid byte) []interface{} {
+		return []interface{}{[]byte{130, rcid, 0, 0, 0}, uint8(1), uint8(1), uint8(255)}
+	}
+	var rows []interface{}
+	for i := byte(1); i <= 3; i++ {
+		rows = append(rows, row(i)...)
+	}
+	base[1].Fields = append(base[1].Fields, Field{Tag: "FSPT", FieldType: lead.FieldTypes["FSPT"], SubFields: rows})
+	for _, c := range []struct {
+		rows         []interface{}
+		instructions []interface{}
+	}{
+		{append(append(row(9), row(2)...), row(3)...), []interface{}{uint8(UpdateModify)}},
+		{append(append(row(1), row(2)...), append(row(3), row(4)...)...), []interface{}{uint8(UpdateInsert)}},
+		{row(9), []interface{}{uint8(UpdateModify), uint8(UpdateDelete)}},
+		{append(append(row(9), row(2)...), append(row(3), row(4)...)...),
+			[]interface{}{uint8(UpdateModify), uint8(UpdateInsert)}},
+	} {
+		modified := []DataRecord{base[0], *base[1].Clone()}
+		fspt, _ := modified[1].Field("FSPT")
+		fspt.SubFields = c.rows
+		update, err := BuildUpdate(base, modified)
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		var instructions []interface{}
+		for _, f := range update[1].Fields {
+			if f.Tag == "FSPC" {
+				instructions = append(instructions, f.SubFields[0])
+			}
+		}
+		if !reflect.DeepEqual(instructions, c.instructions) {
+			t.Error("Expected ", c.instructions, ", got ", instructions)
+		}
+		var buf bytes.Buffer
+		if err = lead.Write(&buf); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		for i := range update {
+			if err = update[i].Write(&buf); err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+		}
+		merged, err := ApplyUpdate(base, &buf)
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		if f, _ := merged[1].Field("FSPT"); f == nil || !reflect.DeepEqual(f.SubFields, c.rows) {
+			t.Error("Expected ", c.rows, ", got ", f)
+		}
+	}
+}
@@ -0,0 +1,464 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Write writes the leader and directory of the Header as they are.
+// DataRecord.Write and LeadRecord.Write compute the RecordLength,
+// BaseAddress and Entries before calling it.
+func (header *Header) Write(file io.Writer) error {
+	return header.write(file, Terminators{})
+}
+
+// write is Write, ending the directory with the field terminator of t.
+func (header *Header) write(file io.Writer, t Terminators) error {
+	b, err := header.rawBytes(t)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(b)
+	return err
+}
+
+// RawBytes returns the leader and directory of the Header as they are on
+// disk: the numbers as zero padded ASCII and the DirEntries in the entry
+// sizes of the Header, ending with the standard field terminator.
+func (header *Header) RawBytes() ([]byte, error) {
+	return header.rawBytes(Terminators{})
+}
+
+// rawBytes is RawBytes, ending with the field terminator of t.
+func (header *Header) rawBytes(t Terminators) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeNumber(&buf, header.RecordLength, 5); err != nil {
+		return nil, fmt.Errorf("record length: %v", err)
+	}
+	buf.WriteByte(orSpace(header.InterchangeLevel))
+	buf.WriteByte(orSpace(header.LeaderID))
+	buf.WriteByte(orSpace(header.InLineCode))
+	buf.WriteByte(orSpace(header.Version))
+	buf.WriteByte(orSpace(header.ApplicationIndicator))
+	if header.FieldControlLength == 0 {
+		buf.WriteString("  ")
+	} else if err := writeNumber(&buf, header.FieldControlLength, 2); err != nil {
+		return nil, fmt.Errorf("field control length: %v", err)
+	}
+	if err := writeNumber(&buf, header.BaseAddress, 5); err != nil {
+		return nil, fmt.Errorf("base address: %v", err)
+	}
+	ext := []byte("   ")
+	copy(ext, header.ExtendedCharacterSetIndicator)
+	buf.Write(ext)
+	buf.WriteByte('0' + byte(header.LengthSize))
+	buf.WriteByte('0' + byte(header.PositionSize))
+	buf.WriteByte('0')
+	buf.WriteByte('0' + byte(header.TagSize))
+	for _, e := range header.Entries {
+		if len(e.Tag) != int(header.TagSize) {
+			return nil, fmt.Errorf("field %s: tag is not %d bytes", e.Tag, header.TagSize)
+		}
+		buf.Write(e.Tag)
+		if err := writeNumber(&buf, uint64(e.Length), int(header.LengthSize)); err != nil {
+			return nil, fmt.Errorf("field %s length: %v", e.Tag, err)
+		}
+		if err := writeNumber(&buf, uint64(e.Position), int(header.PositionSize)); err != nil {
+			return nil, fmt.Errorf("field %s position: %v", e.Tag, err)
+		}
+	}
+	buf.WriteByte(t.field())
+	return buf.Bytes(), nil
+}
+
+// Write encodes the FieldTypes in the order of the Header entries, then
+// any others by tag, and writes the lead record. The Header's
+// RecordLength, BaseAddress and Entries are recomputed. The directory
+// ends with the field terminator of the lead record's Terminators, which
+// the FieldTypes without their own are written with.
+func (lead *LeadRecord) Write(file io.Writer) error {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, e := range lead.Header.Entries {
+		if _, ok := lead.FieldTypes[string(e.Tag)]; ok && !seen[string(e.Tag)] {
+			tags = append(tags, string(e.Tag))
+			seen[string(e.Tag)] = true
+		}
+	}
+	var rest []string
+	for tag := range lead.FieldTypes {
+		if !seen[tag] {
+			rest = append(rest, tag)
+		}
+	}
+	sort.Strings(rest)
+	tags = append(tags, rest...)
+	lead.Header.LeaderID = 'L'
+	if lead.Header.FieldControlLength == 0 {
+		lead.Header.FieldControlLength = 9
+	}
+	fields := make([][]byte, len(tags))
+	for i, tag := range tags {
+		ft := lead.FieldTypes[tag]
+		if ft.Terminators == (Terminators{}) {
+			ft.Terminators = lead.Terminators
+		}
+		fields[i] = ft.encodeDescription(int(lead.Header.FieldControlLength))
+	}
+	return lead.Header.writeRecord(file, tags, fields, lead.Terminators)
+}
+
+// Write encodes the SubFields of each Field with its FieldType and
+// writes the data record. A Field without a FieldType, or not decoded as
+// when read with Lazy, is written from its Raw data; a Field read past
+// with DecodeTags, without KeepRaw, is an error. The Header's RecordLength, BaseAddress and
+// Entries, and the Length and Position of each Field, are recomputed.
+// The directory ends with the field terminator of the Lead's
+// Terminators, or of the first Field's FieldType when the Lead has none.
+func (data *DataRecord) Write(file io.Writer) error {
+	tags := make([]string, len(data.Fields))
+	fields := make([][]byte, len(data.Fields))
+	for i := range data.Fields {
+		f := &data.Fields[i]
+		tags[i] = f.Tag
+		if len(f.Raw) > 0 && (f.FieldType.Tag == "" || f.SubFields == nil) {
+			// Unresolved and undecoded fields are written as they were
+			// read.
+			fields[i] = f.Raw
+			continue
+		}
+		if f.FieldType.Tag == "" {
+			return fmt.Errorf("field %s: no field type to encode it with", f.Tag)
+		}
+		if f.SubFields == nil && f.Length > len(f.FieldType.fieldTerminator()) {
+			// Left out by DecodeTags, there is nothing to write.
+			return fmt.Errorf("field %s: the %d bytes read were not decoded or kept", f.Tag, f.Length)
+		}
+		b, err := f.FieldType.Encode(f.SubFields)
+		if err != nil {
+			return err
+		}
+		fields[i] = append(b, f.FieldType.fieldTerminator()...)
+	}
+	data.Header.LeaderID = 'D'
+	if err := data.Header.writeRecord(file, tags, fields, data.terminators()); err != nil {
+		return err
+	}
+	for i, e := range data.Header.Entries {
+		data.Fields[i].Length = e.Length
+		data.Fields[i].Position = e.Position
+	}
+	return nil
+}
+
+// terminators returns the Terminators of the Lead, or, when it has none,
+// of the FieldType of the first Field.
+func (data *DataRecord) terminators() Terminators {
+	if data.Lead != nil && data.Lead.Terminators != (Terminators{}) {
+		return data.Lead.Terminators
+	}
+	if len(data.Fields) > 0 {
+		return data.Fields[0].FieldType.Terminators
+	}
+	return Terminators{}
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the
+// record as Write writes it.
+func (data *DataRecord) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := data.Write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading the
+// record from b as Read does. The Lead must be set, its FieldTypes decode
+// the Fields, e.g. before decoding a gob into the DataRecord. b must be
+// exactly one record.
+func (data *DataRecord) UnmarshalBinary(b []byte) error {
+	if data.Lead == nil {
+		return errors.New("no lead record to decode the fields with")
+	}
+	r := bytes.NewReader(b)
+	if err := data.Read(r); err != nil {
+		return err
+	}
+	if r.Len() > 0 {
+		return fmt.Errorf("%d bytes after the record", r.Len())
+	}
+	return nil
+}
+
+// writeRecord lays out the directory for the fields, keeping the entry
+// sizes of the header where they are large enough, and writes the record
+// with the field terminator of t after the directory.
+func (header *Header) writeRecord(file io.Writer, tags []string, fields [][]byte, t Terminators) error {
+	header.Entries = make([]DirEntry, len(fields))
+	position, last, longest := 0, 0, 0
+	for i, f := range fields {
+		header.Entries[i] = DirEntry{[]byte(tags[i]), len(f), position}
+		last = position
+		if len(tags[i]) > int(header.TagSize) {
+			header.TagSize = int8(len(tags[i]))
+		}
+		if len(f) > longest {
+			longest = len(f)
+		}
+		position += len(f)
+	}
+	if n := digits(longest); n > header.LengthSize {
+		header.LengthSize = n
+	}
+	if n := digits(last); n > header.PositionSize {
+		header.PositionSize = n
+	}
+	entrySize := uint64(header.TagSize + header.LengthSize + header.PositionSize)
+	header.BaseAddress = uint64(binary.Size(RawHeader{})) + uint64(len(fields))*entrySize + 1
+	header.RecordLength = header.BaseAddress + uint64(position)
+	if err := header.write(file, t); err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if _, err := file.Write(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeDescription returns the lead record field describing the FieldType,
+// with controls bytes of field controls.
+func (dir FieldType) encodeDescription(controls int) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(orSpace(dir.DataStructure))
+	buf.WriteByte(orSpace(dir.DataType))
+	aux := []byte("00")
+	copy(aux, dir.AuxiliaryControls)
+	buf.Write(aux)
+	buf.WriteByte(orSpace(dir.PrintableFt))
+	buf.WriteByte(orSpace(dir.PrintableUt))
+	esc := []byte("   ")
+	if len(dir.EscapeSeq) > 0 {
+		copy(esc, dir.EscapeSeq)
+	} else if dir.LexicalLevel > 0 && dir.LexicalLevel < len(escapeSequences) {
+		copy(esc, escapeSequences[dir.LexicalLevel])
+	}
+	buf.Write(esc)
+	for buf.Len() < controls {
+		buf.WriteByte(' ')
+	}
+	buf.Truncate(controls)
+	unit := dir.Terminators.unit()
+	buf.Write(dir.Name)
+	buf.WriteByte(unit)
+	buf.Write(dir.ArrayDescriptor)
+	if dir.FormatControls != nil {
+		buf.WriteByte(unit)
+		buf.Write(dir.FormatControls)
+	}
+	for _, part := range dir.Extra {
+		buf.WriteByte(unit)
+		buf.Write(part)
+	}
+	buf.WriteByte(dir.Terminators.field())
+	return buf.Bytes()
+}
+
+// Encode is the inverse of Decode, it converts values to the binary file
+// format of the FieldType, without the field terminator. Each value must
+// have the Go type Decode produces for its subfield; a nil ASCII number
+// is written blank. Text must fit the width of its subfield, and text of
+// variable width must not contain a terminator.
+func (dir FieldType) Encode(values []interface{}) ([]byte, error) {
+	types := dir.Format()
+	if len(types) == 0 && len(values) > 0 {
+		return nil, fmt.Errorf("field %s: no format to encode subfields with", dir.Tag)
+	}
+	order := dir.byteOrder()
+	var buf bytes.Buffer
+	for i, v := range values {
+		ftype := types[i%len(types)]
+		ok := true
+		var err error
+		switch ftype.Kind {
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Int8, reflect.Int16, reflect.Int32:
+			ok = reflect.TypeOf(v) != nil && reflect.TypeOf(v).Kind() == ftype.Kind
+			if ok {
+				binary.Write(&buf, order, v)
+			}
+		case reflect.Uint64:
+			var n uint64
+			n, ok = v.(uint64)
+			writeUint(&buf, n, ftype.Size, order)
+		case reflect.Int64:
+			var n int64
+			n, ok = v.(int64)
+			writeUint(&buf, uint64(n), ftype.Size, order)
+		case reflect.Array:
+			var b []byte
+			b, ok = v.([]byte)
+			ok = ok && len(b) == ftype.Size
+			buf.Write(b)
+		case reflect.Int:
+			if v == nil {
+				err = writeText(&buf, "", ftype.Size, dir.Terminators)
+				break
+			}
+			var n int
+			n, ok = v.(int)
+			err = writeText(&buf, padNumber(strconv.Itoa(n), ftype.Size), ftype.Size, dir.Terminators)
+		case reflect.Float32:
+			var n float32
+			n, ok = v.(float32)
+			writeUint(&buf, uint64(math.Float32bits(n)), 4, order)
+		case reflect.Float64:
+			if v == nil && !ftype.Binary {
+				err = writeText(&buf, "", ftype.Size, dir.Terminators)
+				break
+			}
+			var n float64
+			n, ok = v.(float64)
+			if ftype.Binary {
+				writeUint(&buf, math.Float64bits(n), 8, order)
+			} else {
+				err = writeText(&buf, padNumber(strconv.FormatFloat(n, 'f', -1, 64), ftype.Size), ftype.Size, dir.Terminators)
+			}
+		default:
+			var s string
+			s, ok = v.(string)
+			if dir.LexicalLevel == 2 {
+				err = writeUCS2(&buf, s, ftype.Size, dir.Terminators, order)
+			} else {
+				err = writeText(&buf, s, ftype.Size, dir.Terminators)
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("field %s: subfield %s: cannot encode %T as %v", dir.Tag, ftype.Tag, v, ftype.Kind)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("field %s: subfield %s: %v", dir.Tag, ftype.Tag, err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// fieldTerminator returns the field terminator for the lexical level.
+func (dir FieldType) fieldTerminator() []byte {
+	if dir.LexicalLevel == 2 {
+		b := make([]byte, 2)
+		dir.byteOrder().PutUint16(b, uint16(dir.Terminators.field()))
+		return b
+	}
+	return []byte{dir.Terminators.field()}
+}
+
+// writeText writes s padded with spaces to size bytes, or followed by the
+// unit terminator when the size is 0.
+func writeText(buf *bytes.Buffer, s string, size int, t Terminators) error {
+	if size == 0 {
+		if strings.IndexByte(s, t.unit()) >= 0 || strings.IndexByte(s, t.field()) >= 0 {
+			return fmt.Errorf("%q contains a unit or field terminator", s)
+		}
+		buf.WriteString(s)
+		buf.WriteByte(t.unit())
+		return nil
+	}
+	if len(s) > size {
+		return fmt.Errorf("%q is wider than %d bytes", s, size)
+	}
+	buf.WriteString(s)
+	buf.WriteString(strings.Repeat(" ", size-len(s)))
+	return nil
+}
+
+// writeUCS2 is writeText for lexical level 2. An odd size is padded with
+// a space byte, which readUCS2 ignores.
+func writeUCS2(buf *bytes.Buffer, s string, size int, t Terminators, order binary.ByteOrder) error {
+	units := utf16.Encode([]rune(s))
+	if size == 0 {
+		for _, u := range units {
+			if u == uint16(t.unit()) || u == uint16(t.field()) {
+				return fmt.Errorf("%q contains a unit or field terminator", s)
+			}
+		}
+		units = append(units, uint16(t.unit()))
+	} else {
+		if len(units)*2 > size {
+			return fmt.Errorf("%q is wider than %d bytes", s, size)
+		}
+		for len(units) < size/2 {
+			units = append(units, ' ')
+		}
+	}
+	b := make([]byte, 2)
+	for _, u := range units {
+		order.PutUint16(b, u)
+		buf.Write(b)
+	}
+	if size%2 == 1 {
+		buf.WriteByte(' ')
+	}
+	return nil
+}
+
+// writeUint writes the low size bytes of n in the byte order.
+func writeUint(buf *bytes.Buffer, n uint64, size int, order binary.ByteOrder) {
+	var wide [8]byte
+	if order.Uint16([]byte{0, 1}) == 1 {
+		binary.BigEndian.PutUint64(wide[:], n)
+		buf.Write(wide[8-size:])
+		return
+	}
+	binary.LittleEndian.PutUint64(wide[:], n)
+	buf.Write(wide[:size])
+}
+
+// padNumber pads a fixed width ASCII number with leading zeros. A number
+// wider than size is returned as it is, for writeText to reject.
+func padNumber(s string, size int) string {
+	if len(s) >= size {
+		return s
+	}
+	sign := ""
+	if s[0] == '-' {
+		sign, s = "-", s[1:]
+	}
+	return sign + strings.Repeat("0", size-len(s)-len(sign)) + s
+}
+
+// writeNumber writes n as size zero padded ASCII digits.
+func writeNumber(buf *bytes.Buffer, n uint64, size int) error {
+	s := strconv.FormatUint(n, 10)
+	if len(s) > size {
+		return fmt.Errorf("%d does not fit in %d digits", n, size)
+	}
+	buf.WriteString(strings.Repeat("0", size-len(s)))
+	buf.WriteString(s)
+	return nil
+}
+
+func digits(n int) int8 {
+	return int8(len(strconv.Itoa(n)))
+}
+
+func orSpace(b byte) byte {
+	if b == 0 {
+		return ' '
+	}
+	return b
+}
@@ -0,0 +1,98 @@
+// Copyright 2015 Thomas Burke <tburke@tb99.com>. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package iso8211
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"testing"
+)
+
+func TestWriteRoundTrip(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	// Lazy records are written from the Raw data of their fields.
+	for _, lazy := range []bool{false, true} {
+		r := bytes.NewReader(data)
+		var l LeadRecord
+		if err = l.Read(r); err != nil {
+			t.Fatal("Error reading the lead record: ", err)
+		}
+		var out bytes.Buffer
+		if err = l.Write(&out); err != nil {
+			t.Fatal("Error writing the lead record: ", err)
+		}
+		for i := 1; i <= 2; i++ {
+			d := DataRecord{Lead: &l, Lazy: lazy}
+			if err = d.Read(r); err != nil {
+				t.Fatal("Error reading Data record ", i, ": ", err)
+			}
+			if err = d.Write(&out); err != nil {
+				t.Fatal("Error writing Data record ", i, ": ", err)
+			}
+		}
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Errorf("Written file differs, lazy %v\n%q\n%q", lazy, out.Bytes(), data)
+		}
+	}
+}
+
+func TestHeaderRawBytes(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/US5MD12M.001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	for _, offset := range []int{0, 1814, 1814 + 144} {
+		var h Header
+		if err = h.Read(bytes.NewReader(data[offset:])); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		b, err := h.RawBytes()
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		if e := data[offset : offset+int(h.BaseAddress)]; !bytes.Equal(b, e) {
+			t.Errorf("At %d expected %q, got %q", offset, e, b)
+		}
+	}
+	h := Header{RecordLength: 100000}
+	if _, err = h.RawBytes(); err == nil {
+		t.Error("Expected an error for a record length over 5 digits")
+	}
+}
+
+func TestDataRecordMarshalBinary(t *testing.T) {
+	records := readTestRecords(t)
+	for _, d := range records {
+		b, err := d.MarshalBinary()
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		var network bytes.Buffer
+		if err = gob.NewEncoder(&network).Encode(d); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		u := DataRecord{Lead: d.Lead}
+		if err = gob.NewDecoder(&network).Decode(&u); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		if u.String() != d.String() {
+			t.Error("Expected ", d, ", got ", u)
+		}
+		again, err := u.MarshalBinary()
+		if err != nil || !bytes.Equal(again, b) {
+			t.Errorf("Expected the same bytes, got %q %v", again, err)
+		}
+		if err = (&DataRecord{}).UnmarshalBinary(b); err == nil {
+			t.Error("Expected an error without a lead record")
+		}
+		if err = (&DataRecord{Lead: d.Lead}).UnmarshalBinary(append(b, b...)); err == nil {
+			t.Error("Expected an error for the bytes after the record")
+		}
+	}
+}